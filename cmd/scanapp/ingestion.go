@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"scanapp/pkg/config"
+	"scanapp/pkg/wizapi"
+	"strings"
+)
+
+// printIngestionReport prints what Wiz ingested from the upload and returns the exit code of the outcome
+func printIngestionReport(activity *wizapi.SystemActivity, cfg *config.Config) int {
+	outcome := activity.Outcome()
+
+	fmt.Printf("System Activity %s: %s (ingestion %s)\n", activity.ID, activity.Status, outcome)
+	if activity.StatusInfo != "" {
+		fmt.Printf("  %s\n", activity.StatusInfo)
+	}
+
+	for _, stat := range activity.Stats() {
+		line := fmt.Sprintf("  %-13s incoming %6d  handled %6d", stat.Name, stat.Incoming, stat.Handled)
+		if dropped := stat.Dropped(); dropped > 0 {
+			line += fmt.Sprintf("  WARNING: %d not handled", dropped)
+		}
+		fmt.Println(line)
+	}
+
+	unresolved := activity.UnresolvedAssetIDs()
+	if count := activity.Result.UnresolvedAssets.Count; count > 0 || len(unresolved) > 0 {
+		if count < len(unresolved) {
+			count = len(unresolved)
+		}
+		fmt.Printf("  WARNING: %d asset(s) could not be matched to a Wiz resource\n", count)
+		for _, id := range unresolved {
+			fmt.Printf("    %s: %s\n", id, unresolvedAssetHint(id, cfg))
+		}
+	}
+
+	switch outcome {
+	case wizapi.IngestionComplete:
+		return exitOK
+	case wizapi.IngestionPartial:
+		return exitIngestionPartial
+	case wizapi.IngestionPending:
+		return exitIngestionPending
+	default:
+		return exitIngestionFailed
+	}
+}
+
+// unresolvedAssetHint names the config field an unresolved asset ID most likely comes from
+func unresolvedAssetHint(id string, cfg *config.Config) string {
+	field, cloudPlatform := "", ""
+	if id == cfg.ScanProviderID {
+		field, cloudPlatform = "scanProviderId", cfg.ScanCloudType
+	}
+	for i, asset := range cfg.Assets {
+		if field == "" && id == asset.ProviderID {
+			field, cloudPlatform = fmt.Sprintf("assets[%d].providerId", i), asset.CloudPlatform
+		}
+	}
+	if field == "" {
+		return "not in the config, check scanProviderId and assets"
+	}
+
+	// A provider ID that looks like another cloud's resource points at the cloud platform instead
+	if expected := providerIDPlatform(id); expected != "" && !strings.EqualFold(expected, cloudPlatform) {
+		platformField := "scanCloudType"
+		if field != "scanProviderId" {
+			platformField = strings.TrimSuffix(field, "providerId") + "cloudPlatform"
+		}
+		return fmt.Sprintf("looks like a %s resource but %s is '%s'", expected, platformField, cloudPlatform)
+	}
+	return fmt.Sprintf("check %s, it must be the external ID Wiz shows for the resource", field)
+}
+
+// providerIDPlatform guesses the cloud platform from the format of a provider ID
+func providerIDPlatform(id string) string {
+	switch {
+	case strings.HasPrefix(id, "arn:aws"):
+		return "AWS"
+	case strings.HasPrefix(strings.ToLower(id), "/subscriptions/"):
+		return "Azure"
+	case strings.HasPrefix(id, "//compute.googleapis.com/"):
+		return "GCP"
+	default:
+		return ""
+	}
+}
@@ -1,21 +1,59 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
-	"scanapp/pkg/aws"
 	"scanapp/pkg/config" // Adjust the import path based on your module's name and structure
 	"scanapp/pkg/environment"
+	"scanapp/pkg/export"
+	"scanapp/pkg/httpclient"
+	"scanapp/pkg/identity"
+	"scanapp/pkg/policy"
+	"scanapp/pkg/report"
 	"scanapp/pkg/vulnerability"
-	"scanapp/pkg/wizapi" // Adjust the import path based on your module's name and structure
 	"scanapp/pkg/wizcli"
 	"strings"
 	"time"
 )
 
+// Version is set at build time by the Makefile
+var Version = "dev"
+
+// Process exit codes
+const (
+	exitOK              = 0
+	exitError           = 1
+	exitUsage           = 2
+	exitSuspiciousScan  = 3
+	exitPolicyViolation = 4
+	// Upload outcomes reported by Wiz after the upload
+	exitIngestionPartial = 5
+	exitIngestionFailed  = 6
+	exitIngestionPending = 7
+)
+
 func main() {
+	// Subcommands operate on the local state and don't run a scan
+	if len(os.Args) > 1 && os.Args[1] == "state" {
+		os.Exit(runStateCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "wiz" {
+		os.Exit(runWizCommand(os.Args[2:]))
+	}
+
+	os.Exit(runScan())
+}
+
+// runScan scans the host, updates the state and uploads it to Wiz. It returns the process exit code,
+// so deferred cleanup runs before the process exits.
+func runScan() int {
+	// Record when the run started, it identifies the run snapshot
+	startedAt := time.Now().UTC()
+
 	// Parse the command-line arguments and get the configuration
 	var cfg *config.Config
 	var err error
@@ -23,35 +61,83 @@ func main() {
 
 	// Parse the command-line arguments and get the configuration
 	if len(os.Args) > 1 {
-		cfg, configFilePath = config.ParseArgs()
-
-		// Validate the provided configuration
-		if err := cfg.Validate(); err != nil {
-			fmt.Printf("Configuration validation error: %v\n", err)
-			os.Exit(1)
-		}
-
-		// If the save flag is set, save the current configuration to the file
-		if cfg.Save {
-			if err := config.SaveConfig(cfg, configFilePath); err != nil {
-				fmt.Printf("Failed to save configuration to '%s': %v\n", configFilePath, err)
-				os.Exit(1)
-			}
-			fmt.Printf("Configuration saved successfully to '%s'.\n", configFilePath)
+		cfg, configFilePath, err = config.ParseArgs()
+		if err != nil {
+			fmt.Printf("Error reading config from file '%s': %v\n", configFilePath, err)
+			return exitError
 		}
 	} else {
 		// If no flags are provided, try reading the configuration from the file
 		cfg, err = config.ReadConfig(configFilePath)
 		if err != nil {
 			fmt.Printf("Error reading config from file '%s': %v\n", configFilePath, err)
-			os.Exit(1)
+			return exitError
+		}
+	}
+
+	// Validate the configuration, whether it came from flags or from the file alone
+	if err := cfg.Validate(); err != nil {
+		fmt.Printf("Configuration validation error: %v\n", err)
+		return exitError
+	}
+
+	// If the save flag is set, save the current configuration to the file
+	if cfg.Save {
+		if err := config.SaveConfig(cfg.Persistent(), configFilePath); err != nil {
+			fmt.Printf("Failed to save configuration to '%s': %v\n", configFilePath, err)
+			return exitError
+		}
+		fmt.Printf("Configuration saved successfully to '%s'.\n", configFilePath)
+	}
+
+	// Check the export columns before scanning rather than after
+	if err := export.ValidateColumns(cfg.ExportColumns); err != nil {
+		fmt.Println("Configuration validation error:", err)
+		return exitError
+	}
+
+	// Load the policy before scanning so a broken policy fails fast
+	var scanPolicy *policy.Policy
+	if cfg.PolicyFile != "" {
+		if scanPolicy, err = policy.LoadPolicy(cfg.PolicyFile); err != nil {
+			fmt.Println("Error loading policy:", err)
+			return exitError
+		}
+	}
+
+	// Load the suppression rules and VEX documents before scanning too, a typo shouldn't cost a whole scan
+	var suppressions []vulnerability.Suppression
+	if cfg.SuppressionsFile != "" {
+		if suppressions, err = vulnerability.LoadSuppressions(cfg.SuppressionsFile); err != nil {
+			fmt.Println("Error loading suppressions:", err)
+			return exitError
+		}
+	}
+	vexStatements, err := vulnerability.LoadVEXFiles(cfg.VEXFiles)
+	if err != nil {
+		fmt.Println("Error loading VEX documents:", err)
+		return exitError
+	}
+
+	// Fill the host's cloud identity from the instance metadata service
+	if cfg.DiscoverIdentity {
+		if exitCode := discoverIdentity(cfg); exitCode != exitOK {
+			return exitCode
 		}
 	}
 
-	wizCliPath, err := wizcli.SetupEnvironment()
+	// One transport for every outbound call, so proxy and TLS settings apply everywhere
+	transport, err := httpclient.NewTransport(cfg.HTTPOptions())
+	if err != nil {
+		fmt.Println("Error configuring HTTP transport:", err)
+		return exitError
+	}
+	transferClient := httpclient.NewClient(transport, 0) // Downloads and uploads can take long, no timeout
+
+	wizCliPath, err := wizcli.SetupEnvironment(transferClient)
 	if err != nil {
 		fmt.Println("Failed to set up wizcli environment:", err)
-		return
+		return exitError
 	}
 	defer func() {
 		if err := wizcli.CleanupEnvironment(wizCliPath); err != nil {
@@ -63,20 +149,32 @@ func main() {
 	wizDir := filepath.Dir(wizCliPath)
 	if err := os.Setenv("WIZ_DIR", wizDir); err != nil {
 		fmt.Println("Failed to set WIZ_DIR environment variable:", err)
-		return
+		return exitError
 	}
 	//fmt.Printf("WIZ_DIR set to: %s\n", wizDir)
 
-	// Authenticate wizcli using the credentials from the config
-	authMessage, err := wizcli.AuthenticateWizcli(wizCliPath, cfg.WizClientID, cfg.WizClientSecret)
+	// Authenticate wizcli using the credentials of the first endpoint profile
+	profiles, err := cfg.EndpointProfiles()
+	if err != nil {
+		fmt.Println("Error in endpoint profiles:", err)
+		return exitError
+	}
+	authMessage, err := wizcli.AuthenticateWizcli(wizCliPath, profiles[0].WizClientID, profiles[0].WizClientSecret)
 	if err != nil {
 		fmt.Println("Failed to authenticate wizcli:", err)
-		return
+		return exitError
 	}
 
 	fmt.Println(authMessage)
 	//fmt.Println("wizcli is set up and authenticated at:", wizCliPath)
 
+	// The wizcli version is recorded in the run snapshot
+	wizcliVersion, err := wizcli.Version(wizCliPath)
+	if err != nil {
+		fmt.Println("Warning: Failed to determine wizcli version:", err)
+		wizcliVersion = "unknown"
+	}
+
 	// Use the appropriate root path or leave empty for Windows
 	rootPath := "/"
 	if runtime.GOOS == "windows" {
@@ -87,7 +185,12 @@ func main() {
 	directories, err := environment.ListTopLevelDirectories(rootPath)
 	if err != nil {
 		fmt.Println("Error listing directories:", err)
-		return
+		return exitError
+	}
+
+	// Scan the paths of additional assets that are not covered by the top-level directories
+	for _, asset := range cfg.Assets {
+		directories = environment.AddDirectories(directories, asset.Paths)
 	}
 	/*
 		fmt.Println("Top-level directories:")
@@ -96,64 +199,148 @@ func main() {
 			fmt.Println(dir)
 		}
 	*/
-	// Initialize WizAPI client
-	apiClient := wizapi.NewWizAPI(cfg.WizClientID, cfg.WizClientSecret, cfg.WizAuthURL, cfg.WizQueryURL)
-
-	// Authenticate with the WizAPI
-	if err := apiClient.Authenticate(); err != nil {
-		fmt.Println("Failed to authenticate with WizAPI:", err)
-		return
+	// The Wiz API is only needed when uploading, so image pipelines can run without it
+	var endpoints []endpoint
+	if !cfg.SkipUpload {
+		var exitCode int
+		if endpoints, exitCode = connectEndpoints(cfg, profiles, httpclient.NewClient(transport, 60*time.Second)); exitCode != exitOK {
+			return exitCode
+		}
 	}
-	fmt.Println("Authenticated with WizAPI successfully")
 
-	// Call GraphResourceSearch to execute the GraphQL query
-	graphQLResourceResponse, err := apiClient.GraphResourceSearch(cfg)
+	scanResults, err := wizcli.ScanDirectories(directories, wizCliPath)
 
 	if err != nil {
-		fmt.Println("Error executing GraphResourceSearch:", err)
-		return
+		fmt.Println("Error scanning directories:", err)
+		return exitError // or handle the error as needed
 	}
 
-	//fmt.Println("Total Resource Count:", graphQLResourceResponse.Data.GraphSearch.TotalCount)
-
-	if graphQLResourceResponse.Data.GraphSearch.TotalCount != 1 {
-		fmt.Println("Total Resource Count is not equal to 1. Exiting...")
-		os.Exit(1) // Exit with a non-zero status code to indicate an error
+	// Keep track of the directories wizcli failed on
+	var failedTargets []vulnerability.FailedTarget
+	for _, result := range scanResults {
+		if !result.Succeeded() {
+			failedTargets = append(failedTargets, vulnerability.FailedTarget{Directory: result.Directory, Error: result.Error})
+		}
 	}
 
-	// Handle any errors in the response
+	historicalState, err := vulnerability.OpenHistoricalState()
 
-	if len(graphQLResourceResponse.Errors) > 0 {
-		fmt.Println("GraphQL errors:", graphQLResourceResponse.Errors)
-		return
+	if err != nil {
+		fmt.Println("Error opening historical state:", err)
+		return exitError
 	}
 
-	jsonOutputs, err := wizcli.ScanDirectories(directories, wizCliPath)
+	// Process the data
+	currentState, processReport, err := vulnerability.ProcessVulnerabilities(scanResults, cfg, historicalState, suppressions, vexStatements)
 
 	if err != nil {
-		fmt.Println("Error scanning directories:", err)
-		return // or handle the error as needed
+		fmt.Println("Failed to transform scan results to payload:", err)
 	}
 
-	historicalState, err := vulnerability.OpenHistoricalState()
+	// Enrich the findings from the local NVD, EPSS and KEV files
+	if currentState != nil && (cfg.NVDFile != "" || cfg.EPSSFile != "" || cfg.KEVFile != "") {
+		var nvd map[string]vulnerability.CVSSData
+		var epss map[string]vulnerability.EPSSScore
+		var kev map[string]vulnerability.KEVEntry
 
-	if err != nil {
-		fmt.Println("Error opening historical state:", err)
-		return
+		if cfg.NVDFile != "" {
+			if nvd, err = vulnerability.LoadNVD(cfg.NVDFile); err != nil {
+				fmt.Println("Error loading NVD data:", err)
+				return exitError
+			}
+		}
+		if cfg.EPSSFile != "" {
+			if epss, err = vulnerability.LoadEPSS(cfg.EPSSFile); err != nil {
+				fmt.Println("Error loading EPSS scores:", err)
+				return exitError
+			}
+		}
+		if cfg.KEVFile != "" {
+			if kev, err = vulnerability.LoadKEV(cfg.KEVFile); err != nil {
+				fmt.Println("Error loading KEV catalog:", err)
+				return exitError
+			}
+		}
+
+		enriched := vulnerability.EnrichFindings(currentState, nvd, epss, kev)
+		fmt.Printf("Enriched %d findings with NVD, EPSS and KEV data\n", enriched)
 	}
 
-	// Process the data
-	currentState, err := vulnerability.ProcessVulnerabilities(jsonOutputs, cfg, historicalState)
+	// Expired accepted-risk rules are no longer applied and need to be renewed or removed
+	if processReport != nil && len(processReport.ExpiredSuppressions) > 0 {
+		fmt.Printf("WARNING: %d suppression rules have EXPIRED and were not applied:\n", len(processReport.ExpiredSuppressions))
+		for _, suppression := range processReport.ExpiredSuppressions {
+			fmt.Printf("  - %s expired %s (owner %s)\n", suppression, suppression.Expires, suppression.Owner)
+		}
+	}
+	if processReport != nil && processReport.SuppressedCount > 0 {
+		fmt.Printf("%d findings suppressed, they are kept in state but not uploaded\n", processReport.SuppressedCount)
+	}
 
-	if err != nil {
-		fmt.Println("Failed to transform scan results to payload:", err)
+	if processReport != nil {
+		for status, count := range processReport.VEXStatusCounts {
+			fmt.Printf("%d findings marked %s by VEX statements\n", count, status)
+		}
+	}
+
+	// Warn about vendor severities that were reported as None because nothing maps them
+	if processReport != nil && len(processReport.UnmappedSeverities) > 0 {
+		fmt.Println("Warning: Unmapped vendor severities, add them to severityMapping:")
+		for label, count := range processReport.UnmappedSeverities {
+			fmt.Printf("  - %s (%d findings)\n", label, count)
+		}
 	}
 
 	// Check if either currentState or historicalState is empty
 
 	if currentState == nil || len(currentState.DataSources) == 0 || historicalState == nil || len(historicalState.DataSources) == 0 {
 		fmt.Println("Error: Both historicalState and currentState must be populated")
-		return // terminate the program
+		return exitError // terminate the program
+	}
+
+	// The run snapshot records what happened during this run
+	runMetadata := vulnerability.RunMetadata{
+		RunID:         vulnerability.NewRunID(startedAt),
+		StartedAt:     startedAt.Format(time.RFC3339),
+		WizcliVersion: wizcliVersion,
+		Targets:       directories,
+		FailedTargets: failedTargets,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		runMetadata.Host = hostname
+	}
+	if processReport != nil {
+		runMetadata.UnmappedSeverities = processReport.UnmappedSeverities
+	}
+
+	// Compare the run against the run the state files hold before touching history
+	baselineRun, err := vulnerability.CurrentRun()
+	if err != nil {
+		fmt.Println("Error reading previous run snapshots:", err)
+		return exitError
+	}
+
+	sanityCheck := vulnerability.CheckSanity(currentState, directories, failedTargets, baselineRun, cfg.MaxFindingDropPercent)
+	runMetadata.SanityCheck = sanityCheck
+
+	if !sanityCheck.Passed {
+		fmt.Println("The scan looks suspicious:")
+		for _, reason := range sanityCheck.Reasons {
+			fmt.Println("  -", reason)
+		}
+
+		if !cfg.Force {
+			// Keep the run for inspection but leave the state files and history untouched
+			runMetadata.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+			if err := vulnerability.WriteSnapshot(runMetadata, currentState, historicalState, processReport.Inventory); err != nil {
+				fmt.Println("Error writing run snapshot:", err)
+			}
+			fmt.Printf("Refusing to upload run %s, use -force to upload anyway\n", runMetadata.RunID)
+			return exitSuspiciousScan
+		}
+
+		sanityCheck.Forced = true
+		fmt.Println("Continuing because -force was given")
 	}
 
 	// Update the historical state with any new findings from the current state
@@ -161,7 +348,7 @@ func main() {
 
 	if err != nil {
 		fmt.Println("Error updating historical state:", err)
-		return
+		return exitError
 	}
 
 	// Write historicalState to the file
@@ -169,7 +356,7 @@ func main() {
 
 	if err != nil {
 		fmt.Println("Error writing historical state:", err)
-		return
+		return exitError
 	}
 
 	// Write currentState to the file
@@ -177,56 +364,119 @@ func main() {
 
 	if err != nil {
 		fmt.Println("Error writing current state:", err)
-		return
+		return exitError
 	}
 
 	//fmt.Println("Current and historical states written successfully")
 
-	// The filename you wish to upload
-	filename := "state-current.json"
+	// Evaluate the policy over the findings of this run
+	var violations []policy.Violation
+	if scanPolicy != nil {
+		violations = scanPolicy.Evaluate(currentState, time.Now().UTC())
+		printViolations(violations)
+		runMetadata.PolicyViolations = len(violations)
+	}
+
+	// Store the run as an immutable snapshot so history can be rolled back
+	runMetadata.CompletedAt = time.Now().UTC().Format(time.RFC3339)
 
-	// Call RequestSecurityScanUpload to get upload details
-	uploadResponse, err := apiClient.RequestSecurityScanUpload(filename)
-	if err != nil {
-		fmt.Println("Error requesting security scan upload:", err)
-		return
+	if err := vulnerability.WriteSnapshot(runMetadata, currentState, updatedHistoricalState, processReport.Inventory); err != nil {
+		fmt.Println("Error writing run snapshot:", err)
+		return exitError
 	}
+	fmt.Printf("Run snapshot %s written\n", runMetadata.RunID)
 
-	// Call StateUpload to upload the file
-	err = aws.StateUpload(uploadResponse.Data.RequestSecurityScanUpload.Upload.URL, filename)
+	removedRuns, err := vulnerability.ApplyRetention(cfg.SnapshotRetentionRuns, cfg.SnapshotRetentionDays)
 	if err != nil {
-		fmt.Println("Error uploading state file:", err)
-		return
+		fmt.Println("Warning: Failed to apply snapshot retention:", err)
+	}
+	for _, runID := range removedRuns {
+		fmt.Printf("Removed run snapshot %s\n", runID)
 	}
 
-	const maxRetries = 5
-	const retryDelay = 10 // in seconds
+	// Export the findings for other tools
+	if cfg.ExportFormat != "" {
+		opts := export.OptionsForRun(Version, runMetadata)
+		opts.Inventory = processReport.Inventory
+		opts.Columns = cfg.ExportColumns
+		if err := writeOutput(cfg.ExportOutput, func(w io.Writer) error {
+			return export.Write(w, cfg.ExportFormat, currentState, opts)
+		}); err != nil {
+			fmt.Println("Error exporting findings:", err)
+			return exitError
+		}
+	}
 
-	var systemActivityResponse *wizapi.SystemActivityResponse
+	// Render a report of the run for people
+	if cfg.ReportFile != "" {
+		baselineRunID := ""
+		if baselineRun != nil {
+			baselineRunID = baselineRun.RunID
+		}
+		if err := writeReport(cfg.ReportFile, report.FormatForFile(cfg.ReportFile), runMetadata, currentState, baselineRunID, report.DefaultTopPackages); err != nil {
+			fmt.Println("Error writing report:", err)
+			return exitError
+		}
+		fmt.Printf("Report written to %s\n", cfg.ReportFile)
+	}
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		systemActivityResponse, err = apiClient.QuerySystemActivity(uploadResponse.Data.RequestSecurityScanUpload.Upload.SystemActivityId)
-		if err != nil {
-			if strings.Contains(err.Error(), "Resource not found") && attempt < maxRetries-1 {
-				fmt.Printf("Resource not found, retrying in %d seconds...\n", retryDelay)
-				time.Sleep(time.Duration(retryDelay) * time.Second)
-				continue
-			} else {
-				fmt.Printf("Error querying system activity: %v\n", err)
-				return
-			}
-		} else if systemActivityResponse.Data.SystemActivity.Status == "IN_PROGRESS" && attempt < maxRetries-1 {
-			fmt.Printf("Processing upload, retrying in %d seconds...\n", retryDelay)
-			time.Sleep(time.Duration(retryDelay) * time.Second)
-			continue
+	if cfg.SkipUpload {
+		fmt.Println("Skipping upload to Wiz")
+	} else if exitCode := uploadToEndpoints(endpoints, transferClient, currentState, cfg); exitCode != exitOK {
+		return exitCode
+	}
+
+	if len(violations) > 0 {
+		return exitPolicyViolation
+	}
+	return exitOK
+}
+
+// printViolations prints the policy violations of a run
+func printViolations(violations []policy.Violation) {
+	if len(violations) == 0 {
+		fmt.Println("Policy passed")
+		return
+	}
+
+	fmt.Printf("Policy failed with %d violations:\n", len(violations))
+	for _, violation := range violations {
+		fmt.Printf("  - [%s] %s\n", violation.Rule, violation.Message)
+	}
+}
+
+// discoverIdentity fills the empty scan identity fields from the instance metadata service.
+// It fails only when the provider ID is still unknown afterwards.
+func discoverIdentity(cfg *config.Config) int {
+	endpoints := identity.DefaultEndpoints()
+	for _, override := range []struct {
+		target *string
+		value  string
+	}{
+		{&endpoints.AWS, cfg.AWSMetadataURL},
+		{&endpoints.Azure, cfg.AzureMetadataURL},
+		{&endpoints.GCP, cfg.GCPMetadataURL},
+	} {
+		if override.value != "" {
+			*override.target = override.value
 		}
-		break
 	}
 
-	if err == nil {
-		fmt.Printf("System Activity Status: %s\n", systemActivityResponse.Data.SystemActivity.Status)
-	} else {
-		fmt.Println("Failed to query system activity after retries.")
+	hostIdentity, err := identity.NewDiscoverer(endpoints).Discover(context.Background())
+	if err != nil {
+		if cfg.ScanProviderID == "" {
+			fmt.Println("Error discovering the cloud identity:", err)
+			return exitError
+		}
+		fmt.Println("Warning: Failed to discover the cloud identity, using the configured one:", err)
+		return exitOK
 	}
 
+	filled := identity.Apply(cfg, hostIdentity)
+	fmt.Printf("Discovered %s identity %s", hostIdentity.CloudPlatform, hostIdentity.ProviderID)
+	if len(filled) > 0 {
+		fmt.Printf(", filled %s", strings.Join(filled, ", "))
+	}
+	fmt.Println()
+	return exitOK
 }
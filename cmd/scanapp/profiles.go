@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"scanapp/pkg/config"
+	"scanapp/pkg/vulnerability"
+	"scanapp/pkg/wizapi"
+)
+
+// endpoint is a Wiz endpoint profile with its authenticated API client
+type endpoint struct {
+	profile  config.Profile
+	client   *wizapi.WizAPI
+	exitCode int    // exitOK when the endpoint is ready for the upload
+	failure  string // Why the endpoint is not ready
+}
+
+// connectEndpoints authenticates with every endpoint profile and checks that each tenant knows the
+// virtual machine of the host, when it has a provider ID. A profile that fails is recorded as failed and the
+// others go on. It returns the process exit code when no profile is ready.
+func connectEndpoints(cfg *config.Config, profiles []config.Profile, session *http.Client) ([]endpoint, int) {
+	var endpoints []endpoint
+	ready := 0
+	for _, profile := range profiles {
+		apiClient := wizapi.NewWizAPI(session, profile.WizClientID, profile.WizClientSecret, profile.WizAuthURL, profile.WizQueryURL)
+		endpoint := endpoint{profile: profile, client: apiClient, exitCode: exitOK}
+
+		// Authenticate with the WizAPI
+		if err := apiClient.Authenticate(); err != nil {
+			fmt.Printf("Failed to authenticate with WizAPI (profile %s): %v\n", profile.Name, err)
+			endpoint.exitCode, endpoint.failure = exitError, "authentication failed"
+		} else {
+			fmt.Printf("Authenticated with WizAPI successfully (profile %s)\n", profile.Name)
+			if exitCode := checkVirtualMachine(apiClient, cfg); exitCode != exitOK {
+				endpoint.exitCode, endpoint.failure = exitCode, "virtual machine lookup failed"
+			}
+		}
+
+		if endpoint.exitCode == exitOK {
+			ready++
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if ready == 0 {
+		return nil, exitError
+	}
+	return endpoints, exitOK
+}
+
+// checkVirtualMachine checks that Wiz knows the virtual machine the findings are reported for. A host
+// without a provider ID, such as a jump box reporting the configured assets, has nothing to look up.
+func checkVirtualMachine(apiClient *wizapi.WizAPI, cfg *config.Config) int {
+	if cfg.ScanProviderID == "" {
+		fmt.Printf("The host has no provider ID, skipping the virtual machine lookup for the %d configured assets\n", len(cfg.Assets))
+		return exitOK
+	}
+
+	criteria := wizapi.VMCriteria{
+		CloudPlatform:  cfg.ScanCloudType,
+		ProviderID:     cfg.ScanProviderID,
+		SubscriptionID: cfg.ScanSubscriptionID,
+		Region:         cfg.ScanRegion,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		criteria.Hostname = hostname
+	}
+
+	vm, err := apiClient.ResolveVM(context.Background(), criteria)
+	var resolveError *wizapi.ResolveError
+	switch {
+	case errors.As(err, &resolveError) && resolveError.Ambiguous():
+		// Wiz matches the upload by provider ID, so the scan can go on
+		fmt.Println("Warning:", err)
+		fmt.Println("Set scanSubscriptionId or scanRegion to tell them apart")
+	case err != nil:
+		fmt.Println("Error looking up the virtual machine in Wiz:", err)
+		return exitError
+	default:
+		fmt.Printf("Found virtual machine %s in Wiz\n", vm)
+	}
+	return exitOK
+}
+
+// uploadToEndpoints uploads the current state to every ready endpoint, one after the other, so a failing
+// tenant doesn't keep the others from receiving the results. It returns the worst exit code of the endpoints.
+func uploadToEndpoints(endpoints []endpoint, uploadClient *http.Client, currentState *vulnerability.VulnerabilityOutput, cfg *config.Config) int {
+	for i := range endpoints {
+		if endpoints[i].exitCode != exitOK {
+			continue
+		}
+		if len(endpoints) > 1 {
+			fmt.Printf("Uploading to profile %s (%s)\n", endpoints[i].profile.Name, endpoints[i].profile.WizQueryURL)
+		}
+		endpoints[i].exitCode = uploadToWiz(endpoints[i].client, uploadClient, currentState, cfg, endpoints[i].profile)
+	}
+
+	result := exitOK
+	for _, endpoint := range endpoints {
+		if len(endpoints) > 1 {
+			status := endpoint.failure
+			if status == "" {
+				status = uploadStatus(endpoint.exitCode, cfg.NoWait)
+			}
+			fmt.Printf("Upload to profile %s: %s\n", endpoint.profile.Name, status)
+		}
+		if exitCodeSeverity(endpoint.exitCode) > exitCodeSeverity(result) {
+			result = endpoint.exitCode
+		}
+	}
+	return result
+}
+
+// exitCodeSeverity orders the exit codes of uploads from success to outright failure
+func exitCodeSeverity(exitCode int) int {
+	switch exitCode {
+	case exitOK:
+		return 0
+	case exitIngestionPending:
+		return 1
+	case exitIngestionPartial:
+		return 2
+	case exitIngestionFailed:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// uploadStatus describes the exit code of an upload
+func uploadStatus(exitCode int, noWait bool) string {
+	switch exitCode {
+	case exitOK:
+		if noWait {
+			return "uploaded"
+		}
+		return "ingested"
+	case exitIngestionPartial:
+		return "partially ingested"
+	case exitIngestionFailed:
+		return "ingestion failed"
+	case exitIngestionPending:
+		return "ingestion pending"
+	default:
+		return "upload failed"
+	}
+}
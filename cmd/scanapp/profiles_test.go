@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"scanapp/pkg/config"
+	"sync"
+	"testing"
+)
+
+// fakeWiz answers token requests and counts the GraphQL queries, failing every one of them
+type fakeWiz struct {
+	mu      sync.Mutex
+	queries int
+}
+
+func (f *fakeWiz) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/oauth/token" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`))
+		return
+	}
+
+	f.mu.Lock()
+	f.queries++
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+func TestConnectEndpointsSkipsLookupForHostWithoutIdentity(t *testing.T) {
+	wiz := &fakeWiz{}
+	server := httptest.NewServer(wiz)
+	defer server.Close()
+
+	// A jump box without an identity of its own, reporting the assets it collected
+	cfg := &config.Config{
+		ScanCloudType: "AWS",
+		Assets: []config.AssetConfig{
+			{CloudPlatform: "AWS", ProviderID: "arn:aws:ec2:us-east-1:123456789012:instance/i-0aaa", Paths: []string{"/mnt/a"}},
+			{CloudPlatform: "AWS", ProviderID: "arn:aws:ec2:us-east-1:123456789012:instance/i-0bbb", Paths: []string{"/mnt/b"}},
+		},
+	}
+	profiles := []config.Profile{
+		{Name: "default", WizClientID: "id", WizClientSecret: "secret", WizAuthURL: server.URL + "/oauth/token", WizQueryURL: server.URL + "/graphql"},
+		{Name: "secondary", WizClientID: "id", WizClientSecret: "secret", WizAuthURL: server.URL + "/oauth/token", WizQueryURL: server.URL + "/graphql"},
+	}
+
+	endpoints, exitCode := connectEndpoints(cfg, profiles, server.Client())
+	if exitCode != exitOK {
+		t.Fatalf("exit code = %d, want %d", exitCode, exitOK)
+	}
+	if len(endpoints) != 2 {
+		t.Fatalf("endpoints = %d, want 2", len(endpoints))
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.exitCode != exitOK {
+			t.Errorf("profile %s is not ready: %s", endpoint.profile.Name, endpoint.failure)
+		}
+	}
+	if wiz.queries != 0 {
+		t.Errorf("sent %d virtual machine queries, want none", wiz.queries)
+	}
+}
+
+func TestConnectEndpointsLooksUpHostWithIdentity(t *testing.T) {
+	wiz := &fakeWiz{}
+	server := httptest.NewServer(wiz)
+	defer server.Close()
+
+	cfg := &config.Config{ScanCloudType: "AWS", ScanProviderID: "arn:aws:ec2:us-east-1:123456789012:instance/i-0ccc"}
+	profiles := []config.Profile{
+		{Name: "default", WizClientID: "id", WizClientSecret: "secret", WizAuthURL: server.URL + "/oauth/token", WizQueryURL: server.URL + "/graphql"},
+	}
+
+	// The fake tenant rejects the lookup, so the only profile fails
+	if _, exitCode := connectEndpoints(cfg, profiles, server.Client()); exitCode != exitError {
+		t.Errorf("exit code = %d, want %d", exitCode, exitError)
+	}
+	if wiz.queries == 0 {
+		t.Error("the virtual machine of the host was not looked up")
+	}
+}
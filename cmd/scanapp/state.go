@@ -0,0 +1,357 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"scanapp/pkg/config"
+	"scanapp/pkg/export"
+	"scanapp/pkg/policy"
+	"scanapp/pkg/report"
+	"scanapp/pkg/vulnerability"
+	"time"
+)
+
+const stateUsage = `Usage: scanapp state <command> [arguments]
+
+Commands:
+  list                  List the stored run snapshots
+  rollback <runId>      Restore the current and historical state to a run snapshot
+  diff [runA] [runB]    Show what changed between two runs (default: the last two runs)
+  policy [runId]        Evaluate a policy over a run (default: the current state)
+  export [runId]        Export the findings of a run (default: the current state)
+  report [runId]        Render an HTML or Markdown report of a run (default: the latest run)
+`
+
+// runStateCommand dispatches the "state" subcommands and returns the process exit code
+func runStateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Print(stateUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "list":
+		return runStateList(args[1:])
+	case "rollback":
+		return runStateRollback(args[1:])
+	case "diff":
+		return runStateDiff(args[1:])
+	case "policy":
+		return runStatePolicy(args[1:])
+	case "export":
+		return runStateExport(args[1:])
+	case "report":
+		return runStateReport(args[1:])
+	default:
+		fmt.Printf("Unknown state command '%s'\n\n", args[0])
+		fmt.Print(stateUsage)
+		return exitUsage
+	}
+}
+
+// runStateList prints one line per stored run snapshot
+func runStateList(args []string) int {
+	flags := flag.NewFlagSet("state list", flag.ExitOnError)
+	flags.Parse(args)
+
+	runs, err := vulnerability.ListSnapshots()
+	if err != nil {
+		fmt.Println("Error listing run snapshots:", err)
+		return exitError
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("No run snapshots found")
+		return exitOK
+	}
+
+	for _, run := range runs {
+		status := "accepted"
+		if !run.SanityCheck.Accepted() {
+			status = "refused"
+		} else if run.SanityCheck != nil && run.SanityCheck.Forced {
+			status = "forced"
+		}
+
+		fmt.Printf("%s  %-8s  started %s  findings %d  historical %d  wizcli %s\n",
+			run.RunID, status, run.StartedAt, run.FindingCount, run.HistoricalFindingCount, run.WizcliVersion)
+	}
+	return exitOK
+}
+
+// runStateRollback restores the state files from the given run snapshot
+func runStateRollback(args []string) int {
+	flags := flag.NewFlagSet("state rollback", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: scanapp state rollback <runId>")
+		return exitUsage
+	}
+	runID := flags.Arg(0)
+
+	snapshot, err := vulnerability.RollbackState(runID)
+	if err != nil {
+		fmt.Printf("Error rolling back to run %s: %v\n", runID, err)
+		return exitError
+	}
+
+	fmt.Printf("State rolled back to run %s (%d current findings, %d historical findings)\n",
+		snapshot.Metadata.RunID, snapshot.Metadata.FindingCount, snapshot.Metadata.HistoricalFindingCount)
+	return exitOK
+}
+
+// runStateDiff compares two run snapshots and prints the changes
+func runStateDiff(args []string) int {
+	flags := flag.NewFlagSet("state diff", flag.ExitOnError)
+	format := flags.String("format", "text", "Output format: text, json or markdown")
+	output := flags.String("output", "", "Write the diff to this file instead of stdout")
+	flags.Parse(args)
+
+	if flags.NArg() > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: scanapp state diff [-format text|json|markdown] [-output file] [runA] [runB]")
+		return exitUsage
+	}
+
+	runs, err := vulnerability.ListSnapshots()
+	if err != nil {
+		fmt.Println("Error listing run snapshots:", err)
+		return exitError
+	}
+
+	// Default to comparing the previous run with the latest one
+	var fromID, toID string
+	switch flags.NArg() {
+	case 0:
+		if len(runs) < 2 {
+			fmt.Println("At least two run snapshots are needed to compute a diff")
+			return exitError
+		}
+		fromID, toID = runs[len(runs)-2].RunID, runs[len(runs)-1].RunID
+	case 1:
+		if len(runs) == 0 {
+			fmt.Println("No run snapshots found")
+			return exitError
+		}
+		fromID, toID = flags.Arg(0), runs[len(runs)-1].RunID
+	case 2:
+		fromID, toID = flags.Arg(0), flags.Arg(1)
+	}
+
+	fromSnapshot, err := vulnerability.LoadSnapshot(fromID)
+	if err != nil {
+		fmt.Println("Error loading run snapshot:", err)
+		return exitError
+	}
+	toSnapshot, err := vulnerability.LoadSnapshot(toID)
+	if err != nil {
+		fmt.Println("Error loading run snapshot:", err)
+		return exitError
+	}
+
+	diff := vulnerability.DiffStates(fromSnapshot.Current, toSnapshot.Current, fromID, toID)
+
+	if err := writeOutput(*output, func(w io.Writer) error {
+		return vulnerability.WriteDiff(w, diff, *format)
+	}); err != nil {
+		fmt.Println("Error writing diff:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// writeOutput calls write with the named file, or with stdout when the name is empty or "-"
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "" || path == "-" {
+		return write(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// loadRunState returns the current state of the given run, or the state in state-current.json when runID is empty
+func loadRunState(runID string) (*vulnerability.VulnerabilityOutput, error) {
+	if runID == "" {
+		return vulnerability.OpenCurrentState()
+	}
+
+	snapshot, err := vulnerability.LoadSnapshot(runID)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.Current, nil
+}
+
+// runStatePolicy evaluates a policy over a stored state and exits with exitPolicyViolation on violations
+func runStatePolicy(args []string) int {
+	flags := flag.NewFlagSet("state policy", flag.ExitOnError)
+	policyFile := flags.String("policyFile", "", "Path to the JSON policy file")
+	flags.Parse(args)
+
+	if *policyFile == "" || flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: scanapp state policy -policyFile file [runId]")
+		return exitUsage
+	}
+
+	scanPolicy, err := policy.LoadPolicy(*policyFile)
+	if err != nil {
+		fmt.Println("Error loading policy:", err)
+		return exitError
+	}
+
+	state, err := loadRunState(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Error loading state:", err)
+		return exitError
+	}
+
+	violations := scanPolicy.Evaluate(state, time.Now().UTC())
+	printViolations(violations)
+
+	if len(violations) > 0 {
+		return exitPolicyViolation
+	}
+	return exitOK
+}
+
+// runStateExport exports the findings of a stored state
+func runStateExport(args []string) int {
+	flags := flag.NewFlagSet("state export", flag.ExitOnError)
+	format := flags.String("format", "sarif", fmt.Sprintf("Export format: %v", export.Formats))
+	output := flags.String("output", "", "Write the export to this file instead of stdout")
+	var columns config.StringList
+	flags.Var(&columns, "columns", "Comma-separated columns of the CSV export")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: scanapp state export [-format format] [-output file] [-columns list] [runId]")
+		return exitUsage
+	}
+
+	state, err := loadRunState(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Error loading state:", err)
+		return exitError
+	}
+
+	// The current state belongs to the current run, whose snapshot carries the run context
+	runID := flags.Arg(0)
+	if runID == "" {
+		current, err := vulnerability.CurrentRun()
+		if err != nil {
+			fmt.Println("Error finding the current run:", err)
+			return exitError
+		}
+		if current != nil {
+			runID = current.RunID
+		}
+	}
+
+	opts := export.Options{ToolVersion: Version}
+	if runID != "" {
+		// SBOMs are built from the package inventory kept in the run snapshot
+		snapshot, err := vulnerability.LoadSnapshot(runID)
+		if err != nil {
+			fmt.Println("Error loading run snapshot:", err)
+			return exitError
+		}
+		opts = export.OptionsForRun(Version, snapshot.Metadata)
+		opts.Inventory = snapshot.Inventory
+	} else if export.IsSBOMFormat(*format) {
+		fmt.Println("Error: no run snapshot found, an SBOM needs the package inventory of a run")
+		return exitError
+	}
+	opts.Columns = columns
+
+	if err := writeOutput(*output, func(w io.Writer) error {
+		return export.Write(w, *format, state, opts)
+	}); err != nil {
+		fmt.Println("Error exporting findings:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// runStateReport renders a run snapshot as a report, compared against the accepted run before it
+func runStateReport(args []string) int {
+	flags := flag.NewFlagSet("state report", flag.ExitOnError)
+	format := flags.String("format", "", "Report format: html or markdown (default: from the output file name, markdown on stdout)")
+	output := flags.String("output", "", "Write the report to this file instead of stdout")
+	top := flags.Int("top", report.DefaultTopPackages, "Number of packages listed under top packages")
+	noDiff := flags.Bool("noDiff", false, "Leave out the comparison with the previous run")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: scanapp state report [-format html|markdown] [-output file] [-top n] [-noDiff] [runId]")
+		return exitUsage
+	}
+
+	runs, err := vulnerability.ListSnapshots()
+	if err != nil {
+		fmt.Println("Error listing run snapshots:", err)
+		return exitError
+	}
+	if len(runs) == 0 {
+		fmt.Println("No run snapshots found")
+		return exitError
+	}
+
+	runID := flags.Arg(0)
+	if runID == "" {
+		runID = runs[len(runs)-1].RunID
+	}
+
+	// The baseline is the latest accepted run before the reported one
+	baselineRunID := ""
+	if !*noDiff {
+		for i := len(runs) - 1; i >= 0; i-- {
+			if runs[i].RunID < runID && runs[i].SanityCheck.Accepted() {
+				baselineRunID = runs[i].RunID
+				break
+			}
+		}
+	}
+
+	snapshot, err := vulnerability.LoadSnapshot(runID)
+	if err != nil {
+		fmt.Println("Error loading run snapshot:", err)
+		return exitError
+	}
+
+	if *format == "" {
+		*format = report.FormatForFile(*output)
+	}
+	if err := writeReport(*output, *format, snapshot.Metadata, snapshot.Current, baselineRunID, *top); err != nil {
+		fmt.Println("Error writing report:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// writeReport renders the report of a run, with the changes since the baseline run when one is given
+func writeReport(path, format string, metadata vulnerability.RunMetadata, state *vulnerability.VulnerabilityOutput, baselineRunID string, topPackages int) error {
+	var diff *vulnerability.StateDiff
+	if baselineRunID != "" {
+		baseline, err := vulnerability.LoadSnapshot(baselineRunID)
+		if err != nil {
+			return fmt.Errorf("cannot load baseline run: %v", err)
+		}
+		diff = vulnerability.DiffStates(baseline.Current, state, baselineRunID, metadata.RunID)
+	}
+
+	runReport := report.Build(metadata, state, diff, topPackages)
+	return writeOutput(path, func(w io.Writer) error {
+		return report.Write(w, format, runReport)
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"scanapp/pkg/vulnerability"
+	"testing"
+	"time"
+)
+
+// inTempDir runs the test from an empty directory, where the state files and snapshots are written
+func inTempDir(t *testing.T) {
+	t.Helper()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+// writeAcceptedRun writes the state files and the snapshot of an accepted run with a single finding
+func writeAcceptedRun(t *testing.T, startedAt time.Time, cve string) string {
+	t.Helper()
+	state := &vulnerability.VulnerabilityOutput{
+		IntegrationID: "integration",
+		DataSources: []vulnerability.DataSource{{
+			ID: "data-source",
+			Assets: []vulnerability.Asset{{
+				AssetIdentifier:       vulnerability.AssetIdentifier{CloudPlatform: "AWS", ProviderId: "i-0123"},
+				VulnerabilityFindings: []vulnerability.VulnerabilityFinding{{ID: "1", Name: cve, Severity: "High"}},
+			}},
+		}},
+	}
+
+	if err := vulnerability.WriteCurrentState(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := vulnerability.WriteHistoricalState(state); err != nil {
+		t.Fatal(err)
+	}
+	metadata := vulnerability.RunMetadata{
+		RunID:     vulnerability.NewRunID(startedAt),
+		StartedAt: startedAt.Format(time.RFC3339),
+		Host:      "host-" + cve,
+	}
+	if err := vulnerability.WriteSnapshot(metadata, state, state, []vulnerability.InventoryItem{}); err != nil {
+		t.Fatal(err)
+	}
+	return metadata.RunID
+}
+
+func TestStateExportAfterRollbackUsesRestoredRun(t *testing.T) {
+	inTempDir(t)
+
+	started := time.Now().UTC().Add(-time.Hour)
+	olderRun := writeAcceptedRun(t, started, "CVE-2023-0001")
+	newerRun := writeAcceptedRun(t, started.Add(time.Minute), "CVE-2023-0002")
+
+	if exitCode := runStateRollback([]string{olderRun}); exitCode != exitOK {
+		t.Fatalf("rollback exit code = %d", exitCode)
+	}
+
+	output := filepath.Join(t.TempDir(), "findings.jsonl")
+	if exitCode := runStateExport([]string{"-format", "jsonl", "-output", output}); exitCode != exitOK {
+		t.Fatalf("export exit code = %d", exitCode)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+		var finding struct {
+			RunID string `json:"runId"`
+			Host  string `json:"host"`
+			Name  string `json:"name"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &finding); err != nil {
+			t.Fatalf("invalid export line %q: %v", scanner.Text(), err)
+		}
+		if finding.RunID != olderRun || finding.Host != "host-CVE-2023-0001" || finding.Name != "CVE-2023-0001" {
+			t.Errorf("exported %s of run %s on %s, want CVE-2023-0001 of the restored run %s", finding.Name, finding.RunID, finding.Host, olderRun)
+		}
+	}
+	if lines != 1 {
+		t.Errorf("exported %d findings, want 1", lines)
+	}
+
+	// The next scan is checked against the restored run, not the newer one
+	baseline, err := vulnerability.CurrentRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseline == nil || baseline.RunID != olderRun {
+		t.Errorf("sanity baseline = %v, want %s instead of %s", baseline, olderRun, newerRun)
+	}
+
+	// Retention keeps the restored run even when it is past the limit
+	if _, err := vulnerability.ApplyRetention(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vulnerability.LoadSnapshot(olderRun); err != nil {
+		t.Errorf("retention removed the restored run: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"scanapp/pkg/aws"
+	"scanapp/pkg/config"
+	"scanapp/pkg/retry"
+	"scanapp/pkg/vulnerability"
+	"scanapp/pkg/wizapi"
+	"time"
+)
+
+// uploadToWiz uploads the current state to the Wiz endpoint of a profile, waits for it to be processed
+// and reports what was ingested. It returns the process exit code.
+func uploadToWiz(apiClient *wizapi.WizAPI, uploadClient *http.Client, currentState *vulnerability.VulnerabilityOutput, cfg *config.Config, profile config.Profile) int {
+	var err error
+	ctx := context.Background()
+
+	// Write the payload for Wiz, suppressed findings are kept in state but not uploaded
+	// Each profile reports the findings under its own integration and data source
+	payload := vulnerability.UploadPayload(currentState)
+	payload.IntegrationID = profile.IntegrationID
+	for i := range payload.DataSources {
+		payload.DataSources[i].ID = profile.DataSourceID
+	}
+	if err := vulnerability.WriteUploadState(payload); err != nil {
+		fmt.Println("Error writing upload payload:", err)
+		return exitError
+	}
+
+	// The filename you wish to upload
+	filename := vulnerability.UploadStateFile
+
+	// Call RequestSecurityScanUpload to get upload details
+	upload, err := apiClient.RequestSecurityScanUpload(ctx, filename)
+	if err != nil {
+		fmt.Println("Error requesting security scan upload:", err)
+		return exitError
+	}
+
+	// Call StateUpload to upload the file
+	uploadOptions := aws.UploadOptions{
+		Gzip:            cfg.UploadGzip,
+		ChecksumHeaders: cfg.UploadChecksumHeaders,
+		Retry:           retry.Default(),
+		OnProgress:      uploadProgress(),
+	}
+	uploadOptions.Retry.OnRetry = func(attempt int, delay time.Duration, reason string) {
+		fmt.Printf("Retrying upload in %s (attempt %d) after %s\n", delay.Round(time.Millisecond), attempt, reason)
+	}
+	uploadResult, err := aws.StateUpload(ctx, uploadClient, upload.URL, filename, uploadOptions)
+	if err != nil {
+		fmt.Println("Error uploading state file:", err)
+		return exitError
+	}
+	fmt.Printf("Vulnerability information uploaded successfully (%d bytes sent in %s, %s)\n",
+		uploadResult.BodySize, uploadResult.Duration.Round(time.Millisecond), uploadResult.Status)
+
+	if cfg.NoWait {
+		fmt.Printf("System Activity ID: %s\n", upload.SystemActivityId)
+		return exitOK
+	}
+
+	// Wait for Wiz to process the upload, the activity may take a moment to show up
+	opts := wizapi.DefaultWaitOptions()
+	if cfg.UploadWaitTimeout > 0 {
+		opts.Timeout = time.Duration(cfg.UploadWaitTimeout) * time.Second
+	}
+	opts.OnProgress = func(progress wizapi.WaitProgress) {
+		status := "not found yet"
+		if progress.Activity != nil {
+			status = progress.Activity.Status
+		}
+		fmt.Printf("System activity %s, checking again in %s...\n", status, progress.NextDelay.Round(time.Second))
+	}
+
+	result, err := apiClient.WaitForSystemActivity(ctx, upload.SystemActivityId, opts)
+	if err != nil {
+		fmt.Println("Error waiting for system activity:", err)
+		return exitError
+	}
+
+	switch result.State {
+	case wizapi.WaitNotFound:
+		fmt.Printf("System activity %s not found after %s, check it in Wiz later\n", upload.SystemActivityId, result.Elapsed.Round(time.Second))
+		return exitIngestionPending
+	case wizapi.WaitTimedOut:
+		fmt.Printf("System activity %s still %s after %s\n", upload.SystemActivityId, result.Activity.Status, result.Elapsed.Round(time.Second))
+	}
+	return printIngestionReport(result.Activity, cfg)
+}
+
+// uploadProgress returns a progress callback that prints every quarter of large uploads
+func uploadProgress() func(sent, total int64) {
+	const minReportedSize = 1 << 20
+	var lastSent, reported int64
+	return func(sent, total int64) {
+		if total < minReportedSize {
+			return
+		}
+		// A retried attempt starts over
+		if sent < lastSent {
+			reported = 0
+		}
+		lastSent = sent
+
+		if quarter := sent * 4 / total; quarter > reported {
+			reported = quarter
+			fmt.Printf("Uploaded %d of %d bytes (%d%%)\n", sent, total, sent*100/total)
+		}
+	}
+}
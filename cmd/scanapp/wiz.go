@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"scanapp/pkg/config"
+	"scanapp/pkg/httpclient"
+	"scanapp/pkg/wizapi"
+	"strings"
+	"time"
+)
+
+const wizUsage = `Usage: scanapp wiz <command> [arguments]
+
+Commands:
+  search                Search the Wiz security graph and print the matching entities as JSON
+`
+
+const wizSearchUsage = `Usage: scanapp wiz search [-config file] [-profile name] [-type list] [-name name] [-where prop=value] [-tag key=value]
+                         [-related [<]RELATIONSHIP:TYPE[:prop=value]] [-project id] [-limit n] [-output file]
+
+Property predicates use = for EQUALS, ~= for CONTAINS and ^= for STARTS_WITH, e.g. -where name~=nginx.
+A related entity prefixed with < follows the relationship in reverse, e.g. -related "<RUNS:VIRTUAL_MACHINE:name=web-1"
+finds the containers run by the virtual machine web-1.
+`
+
+// repeatedFlag collects every value of a flag given several times
+type repeatedFlag []string
+
+// String returns the values joined by commas
+func (r *repeatedFlag) String() string {
+	return strings.Join(*r, ",")
+}
+
+// Set appends the value
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// runWizCommand dispatches the "wiz" subcommands and returns the process exit code
+func runWizCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Print(wizUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "search":
+		return runWizSearch(args[1:])
+	default:
+		fmt.Printf("Unknown wiz command '%s'\n\n", args[0])
+		fmt.Print(wizUsage)
+		return exitUsage
+	}
+}
+
+// runWizSearch runs an ad-hoc graph search with the credentials and network settings of the config file
+func runWizSearch(args []string) int {
+	flags := flag.NewFlagSet("wiz search", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, wizSearchUsage)
+		flags.PrintDefaults()
+	}
+	configFile := flags.String("config", "config.json", "Config file with the Wiz credentials and endpoints")
+	profileName := flags.String("profile", config.DefaultProfileName, "Endpoint profile to search")
+	types := config.StringList{wizapi.EntityVirtualMachine}
+	flags.Var(&types, "type", "Comma-separated entity types, e.g. VIRTUAL_MACHINE,CONTAINER,SERVERLESS")
+	name := flags.String("name", "", "Entity name, a shorthand for -where name=value")
+	var where, tags, related repeatedFlag
+	flags.Var(&where, "where", "Property predicate prop=value, prop~=value or prop^=value (repeatable)")
+	flags.Var(&tags, "tag", "Tag key=value, or key alone for any value (repeatable)")
+	flags.Var(&related, "related", "Related entity [<]RELATIONSHIP:TYPE[:prop=value] (repeatable)")
+	project := flags.String("project", "", "Project ID to scope the search to (default: all projects)")
+	limit := flags.Int("limit", 100, "Maximum number of entities printed, zero for all")
+	output := flags.String("output", "", "Write the entities to this file instead of stdout")
+	flags.Parse(args)
+
+	if flags.NArg() > 0 || len(types) == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	query := wizapi.NewQuery(types...)
+	if *name != "" {
+		query.WhereEquals("name", *name)
+	}
+	for _, predicate := range where {
+		if err := addPredicate(query, predicate); err != nil {
+			fmt.Println("Error:", err)
+			return exitUsage
+		}
+	}
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, "=")
+		query.WhereTag(key, value)
+	}
+	for _, relation := range related {
+		if err := addRelated(query, relation); err != nil {
+			fmt.Println("Error:", err)
+			return exitUsage
+		}
+	}
+	if *project != "" {
+		query.InProject(*project)
+	}
+
+	cfg, err := config.ReadConfig(*configFile)
+	if err != nil {
+		fmt.Println("Error reading config file:", err)
+		return exitError
+	}
+	transport, err := httpclient.NewTransport(cfg.HTTPOptions())
+	if err != nil {
+		fmt.Println("Error creating HTTP transport:", err)
+		return exitError
+	}
+
+	profile, err := cfg.Profile(*profileName)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitUsage
+	}
+
+	apiClient := wizapi.NewWizAPI(httpclient.NewClient(transport, 60*time.Second), profile.WizClientID, profile.WizClientSecret, profile.WizAuthURL, profile.WizQueryURL)
+	if err := apiClient.Authenticate(); err != nil {
+		fmt.Println("Error authenticating with WizAPI:", err)
+		return exitError
+	}
+
+	entities, err := apiClient.CollectEntities(context.Background(), query, *limit)
+	if err != nil {
+		fmt.Println("Error searching the Wiz graph:", err)
+		return exitError
+	}
+	if entities == nil {
+		entities = []wizapi.GraphEntity{} // Print an empty array rather than null
+	}
+
+	err = writeOutput(*output, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entities)
+	})
+	if err != nil {
+		fmt.Println("Error writing search results:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// addPredicate adds a prop=value, prop~=value or prop^=value predicate to the query
+func addPredicate(query *wizapi.Query, predicate string) error {
+	property, value, found := strings.Cut(predicate, "=")
+	if !found || property == "" {
+		return fmt.Errorf("invalid predicate '%s', expected prop=value", predicate)
+	}
+
+	operator := wizapi.OpEquals
+	if strings.HasSuffix(property, "~") {
+		operator = wizapi.OpContains
+	} else if strings.HasSuffix(property, "^") {
+		operator = wizapi.OpStartsWith
+	}
+	property = strings.TrimRight(property, "~^")
+	if property == "" {
+		return fmt.Errorf("invalid predicate '%s', missing property", predicate)
+	}
+
+	query.Where(property, operator, value)
+	return nil
+}
+
+// addRelated adds a [<]RELATIONSHIP:TYPE[:prop=value] related entity to the query
+func addRelated(query *wizapi.Query, relation string) error {
+	reverse := strings.HasPrefix(relation, "<")
+	parts := strings.SplitN(strings.TrimPrefix(relation, "<"), ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid related entity '%s', expected [<]RELATIONSHIP:TYPE[:prop=value]", relation)
+	}
+
+	with := wizapi.NewQuery(strings.ToUpper(parts[1]))
+	if len(parts) == 3 {
+		if err := addPredicate(with, parts[2]); err != nil {
+			return err
+		}
+	}
+	query.Related(strings.ToUpper(parts[0]), reverse, with)
+	return nil
+}
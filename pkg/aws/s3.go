@@ -1,50 +1,200 @@
 package aws
 
 import (
-	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
+	"scanapp/pkg/retry"
+	"strings"
+	"time"
 )
 
-// StateUpload uploads a file to the provided upload URL.
-func StateUpload(uploadURL, filePath string) error {
-	// Open the file that needs to be uploaded.
-	file, err := os.Open(filePath)
+// UploadOptions controls how StateUpload sends the file
+type UploadOptions struct {
+	// Compress the file with gzip and send it with Content-Encoding: gzip
+	Gzip bool
+	// Send Content-MD5 and x-amz-checksum-sha256 so the storage verifies the body. The SHA-256
+	// header is an x-amz header, so a pre-signed URL must have been signed to allow it.
+	ChecksumHeaders bool
+	// Retry policy of the PUT request, retry.Default when MaxAttempts is zero
+	Retry retry.Policy
+	// Called while the body is sent with the bytes sent so far and the body size, may be nil
+	OnProgress func(sent, total int64)
+}
+
+// UploadResult describes a completed upload
+type UploadResult struct {
+	StatusCode int
+	Status     string
+	ETag       string
+	FileSize   int64 // Size of the file on disk
+	BodySize   int64 // Size of the request body, smaller than FileSize when compressed
+	Compressed bool
+	MD5        string // Base64 MD5 of the body, as sent in Content-MD5
+	SHA256     string // Base64 SHA-256 of the body, as sent in x-amz-checksum-sha256
+	Attempts   int
+	Duration   time.Duration
+}
+
+// StateUpload streams a file to the provided upload URL with the given client. The file is never
+// held in memory: checksums are computed in a first pass, a compressed copy goes to a temporary
+// file, and every attempt reads the body from disk again.
+func StateUpload(ctx context.Context, client *http.Client, uploadURL, filePath string, opts UploadOptions) (*UploadResult, error) {
+	started := time.Now()
+
+	info, err := os.Stat(filePath)
 	if err != nil {
-		return fmt.Errorf("cannot open file: %v", err)
+		return nil, fmt.Errorf("cannot open file: %v", err)
 	}
-	defer file.Close()
+	result := &UploadResult{FileSize: info.Size(), Compressed: opts.Gzip}
 
-	// Read the file's contents into memory
-	fileContents, err := io.ReadAll(file)
-	if err != nil {
-		return fmt.Errorf("cannot read file contents: %v", err)
+	// The body is the file itself or its compressed copy
+	bodyPath := filePath
+	if opts.Gzip {
+		compressedPath, err := compressFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(compressedPath)
+		bodyPath = compressedPath
 	}
 
-	// Create a new request with the file contents
-	req, err := http.NewRequest("PUT", uploadURL, bytes.NewReader(fileContents))
+	result.BodySize, result.MD5, result.SHA256, err = checksumFile(bodyPath)
 	if err != nil {
-		return fmt.Errorf("cannot create request: %v", err)
+		return nil, err
+	}
+
+	policy := opts.Retry
+	if policy.MaxAttempts == 0 {
+		policy = retry.Default()
+		policy.OnRetry = opts.Retry.OnRetry
 	}
 
-	// Set the appropriate headers (if your server expects a specific content type, set it here)
-	req.Header.Set("Content-Type", "application/octet-stream")
+	// Perform the upload request, the body is opened again for every attempt. PUT is idempotent.
+	resp, err := policy.Do(ctx, client, true, func(ctx context.Context) (*http.Request, error) {
+		result.Attempts++
 
-	// Perform the upload request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+		body, err := os.Open(bodyPath)
+		if err != nil {
+			return nil, err
+		}
+		var reader io.ReadCloser = body
+		if opts.OnProgress != nil {
+			reader = &progressReader{ReadCloser: body, total: result.BodySize, onProgress: opts.OnProgress}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, reader)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		req.ContentLength = result.BodySize
+		if result.BodySize == 0 {
+			req.Body = http.NoBody // A zero length with a body would be sent chunked
+		}
+
+		// Set the appropriate headers (if your server expects a specific content type, set it here)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if opts.Gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		if opts.ChecksumHeaders {
+			req.Header.Set("Content-MD5", result.MD5)
+			req.Header.Set("x-amz-checksum-sha256", result.SHA256)
+		}
+		return req, nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to make request: %v", err)
+		return nil, fmt.Errorf("failed to make request: %v", err)
 	}
 	defer resp.Body.Close()
 
+	result.StatusCode = resp.StatusCode
+	result.Status = resp.Status
+	result.ETag = strings.Trim(resp.Header.Get("ETag"), `"`)
+	result.Duration = time.Since(started)
+
 	// Check for a successful response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if message := strings.TrimSpace(string(detail)); message != "" {
+			return result, fmt.Errorf("bad status: %s: %s", resp.Status, message)
+		}
+		return result, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return result, nil
+}
+
+// compressFile writes a gzip copy of the file to a temporary file and returns its path
+func compressFile(filePath string) (string, error) {
+	source, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("cannot open file: %v", err)
+	}
+	defer source.Close()
+
+	target, err := os.CreateTemp("", "scanapp-upload-*.gz")
+	if err != nil {
+		return "", fmt.Errorf("cannot create compressed file: %v", err)
 	}
 
-	fmt.Println("Vulnerability information uploaded successfully")
-	return nil
+	writer := gzip.NewWriter(target)
+	_, err = io.Copy(writer, source)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target.Name())
+		return "", fmt.Errorf("cannot compress file: %v", err)
+	}
+	return target.Name(), nil
+}
+
+// checksumFile returns the size and the base64 MD5 and SHA-256 of a file
+func checksumFile(filePath string) (int64, string, string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("cannot open file: %v", err)
+	}
+	defer file.Close()
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), file)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("cannot read file contents: %v", err)
+	}
+	return size, encodeSum(md5Hash), encodeSum(sha256Hash), nil
+}
+
+// encodeSum returns the base64 encoding of a hash, as used by the checksum headers
+func encodeSum(h hash.Hash) string {
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// progressReader reports the bytes read from the body of an attempt
+type progressReader struct {
+	io.ReadCloser
+	sent       int64
+	total      int64
+	onProgress func(sent, total int64)
+}
+
+// Read reads from the body and reports the progress
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.onProgress(r.sent, r.total)
+	}
+	return n, err
 }
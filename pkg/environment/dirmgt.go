@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 // Exclusion map
@@ -51,3 +52,22 @@ func ListTopLevelDirectories(rootPath string) ([]string, error) {
 
 	return directories, nil
 }
+
+// AddDirectories appends the extra directories that are not already covered by one of the directories.
+// It's used to scan asset paths, such as hosts mounted on a jump box, that live under excluded directories.
+func AddDirectories(directories []string, extra []string) []string {
+	for _, dir := range extra {
+		covered := false
+		for _, existing := range directories {
+			rel, err := filepath.Rel(existing, dir)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			directories = append(directories, dir)
+		}
+	}
+	return directories
+}
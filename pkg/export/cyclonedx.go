@@ -0,0 +1,287 @@
+package export
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"scanapp/pkg/vulnerability"
+	"strings"
+	"time"
+)
+
+const cycloneDXSpecVersion = "1.5"
+
+// cycloneDXSeverities maps Wiz severities to CycloneDX rating severities
+var cycloneDXSeverities = map[string]string{
+	"Critical": "critical",
+	"High":     "high",
+	"Medium":   "medium",
+	"Low":      "low",
+	"None":     "none",
+}
+
+type cdxBOM struct {
+	BOMFormat       string             `json:"bomFormat"`
+	SpecVersion     string             `json:"specVersion"`
+	SerialNumber    string             `json:"serialNumber"`
+	Version         int                `json:"version"`
+	Metadata        cdxMetadata        `json:"metadata"`
+	Components      []cdxComponent     `json:"components"`
+	Dependencies    []cdxDependency    `json:"dependencies,omitempty"`
+	Vulnerabilities []cdxVulnerability `json:"vulnerabilities,omitempty"`
+}
+
+type cdxMetadata struct {
+	Timestamp  string        `json:"timestamp"`
+	Tools      cdxTools      `json:"tools"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Type       string        `json:"type"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	CPE        string        `json:"cpe,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+type cdxVulnerability struct {
+	BOMRef         string       `json:"bom-ref"`
+	ID             string       `json:"id"`
+	Source         *cdxSource   `json:"source,omitempty"`
+	Ratings        []cdxRating  `json:"ratings,omitempty"`
+	Description    string       `json:"description,omitempty"`
+	Recommendation string       `json:"recommendation,omitempty"`
+	Analysis       *cdxAnalysis `json:"analysis,omitempty"`
+	Affects        []cdxAffect  `json:"affects"`
+}
+
+type cdxSource struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+type cdxRating struct {
+	Score    float64 `json:"score,omitempty"`
+	Severity string  `json:"severity"`
+	Method   string  `json:"method,omitempty"`
+	Vector   string  `json:"vector,omitempty"`
+}
+
+type cdxAnalysis struct {
+	State         string   `json:"state"`
+	Justification string   `json:"justification,omitempty"`
+	Response      []string `json:"response,omitempty"`
+	Detail        string   `json:"detail,omitempty"`
+}
+
+type cdxAffect struct {
+	Ref string `json:"ref"`
+}
+
+// cycloneDXStates maps VEX statuses to CycloneDX analysis states
+var cycloneDXStates = map[string]string{
+	vulnerability.VEXNotAffected:        "not_affected",
+	vulnerability.VEXFixed:              "resolved",
+	vulnerability.VEXAffected:           "exploitable",
+	vulnerability.VEXUnderInvestigation: "in_triage",
+}
+
+// cycloneDXJustifications maps OpenVEX justifications to CycloneDX ones
+var cycloneDXJustifications = map[string]string{
+	"component_not_present":                             "code_not_present",
+	"vulnerable_code_not_present":                       "code_not_present",
+	"vulnerable_code_not_in_execute_path":               "code_not_reachable",
+	"vulnerable_code_cannot_be_controlled_by_adversary": "requires_environment",
+	"inline_mitigations_already_exist":                  "protected_by_mitigating_control",
+}
+
+// newSerialNumber returns a random RFC 4122 UUID URN
+func newSerialNumber() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("cannot generate serial number: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // Variant 10
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// inventoryKey identifies a package of an asset, findings are matched to components by it
+func inventoryKey(asset vulnerability.AssetIdentifier, name, version, path string) string {
+	return strings.Join([]string{vulnerability.AssetKey(asset), name, version, path}, "\n")
+}
+
+// inventoryComponent describes an inventory item as a CycloneDX component
+func inventoryComponent(ref string, item vulnerability.InventoryItem) cdxComponent {
+	componentType := "library"
+	if item.Section == "applications" {
+		componentType = "application"
+	}
+
+	component := cdxComponent{
+		BOMRef:  ref,
+		Type:    componentType,
+		Name:    item.Name,
+		Version: item.Version,
+		PURL:    PackageURL(item),
+		Properties: []cdxProperty{
+			{Name: "scanapp:asset", Value: item.Asset.ProviderId},
+			{Name: "scanapp:section", Value: item.Section},
+		},
+	}
+	if isCPE(item.Name) {
+		component.CPE = item.Name
+	}
+	if item.Path != "" {
+		component.Properties = append(component.Properties, cdxProperty{Name: "scanapp:path", Value: item.Path})
+	}
+	if item.DetectionMethod != "" {
+		component.Properties = append(component.Properties, cdxProperty{Name: "scanapp:detectionMethod", Value: item.DetectionMethod})
+	}
+	return component
+}
+
+// findingAnalysis describes the VEX statement or accepted risk of a finding as a CycloneDX analysis
+func findingAnalysis(finding vulnerability.VulnerabilityFinding) *cdxAnalysis {
+	if finding.VEX != nil {
+		if state, known := cycloneDXStates[finding.VEX.Status]; known {
+			return &cdxAnalysis{
+				State:         state,
+				Justification: cycloneDXJustifications[finding.VEX.Justification],
+				Detail:        finding.VEX.Detail,
+			}
+		}
+	}
+	if finding.Suppression != nil {
+		return &cdxAnalysis{
+			State:    "exploitable",
+			Response: []string{"will_not_fix"},
+			Detail:   suppressionJustification(finding.Suppression),
+		}
+	}
+	return nil
+}
+
+// WriteCycloneDX writes the inventory as a CycloneDX 1.5 SBOM, with the findings of the state as vulnerabilities
+func WriteCycloneDX(w io.Writer, state *vulnerability.VulnerabilityOutput, opts Options) error {
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  cycloneDXSpecVersion,
+		SerialNumber: serialNumber,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools: cdxTools{Components: []cdxComponent{
+				{Type: "application", Name: "scanapp", Version: opts.ToolVersion},
+			}},
+		},
+		Components: []cdxComponent{},
+	}
+	if opts.RunID != "" {
+		bom.Metadata.Properties = []cdxProperty{{Name: "scanapp:runId", Value: opts.RunID}}
+	}
+
+	// Every asset is a device that contains its packages
+	assetRefs := make(map[string]string)
+	dependencies := make(map[string][]string)
+	var assetOrder []string
+	assetRef := func(asset vulnerability.AssetIdentifier) string {
+		key := vulnerability.AssetKey(asset)
+		if ref, exists := assetRefs[key]; exists {
+			return ref
+		}
+		ref := fmt.Sprintf("asset-%d", len(assetRefs)+1)
+		assetRefs[key] = ref
+		assetOrder = append(assetOrder, ref)
+		bom.Components = append(bom.Components, cdxComponent{
+			BOMRef: ref,
+			Type:   "device",
+			Name:   assetName(asset, opts.Host),
+			Properties: []cdxProperty{
+				{Name: "scanapp:cloudPlatform", Value: asset.CloudPlatform},
+			},
+		})
+		return ref
+	}
+
+	componentRefs := make(map[string]string)
+	for i, item := range opts.Inventory {
+		parent := assetRef(item.Asset)
+		ref := fmt.Sprintf("component-%d", i+1)
+		componentRefs[inventoryKey(item.Asset, item.Name, item.Version, item.Path)] = ref
+		dependencies[parent] = append(dependencies[parent], ref)
+		bom.Components = append(bom.Components, inventoryComponent(ref, item))
+	}
+
+	for _, ref := range assetOrder {
+		bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: ref, DependsOn: dependencies[ref]})
+	}
+
+	for _, dataSource := range state.DataSources {
+		for _, asset := range dataSource.Assets {
+			for _, finding := range asset.VulnerabilityFindings {
+				// Findings of packages missing from the inventory are attached to their asset
+				ref, exists := componentRefs[inventoryKey(asset.AssetIdentifier, finding.DetailedName, finding.Version, vulnerability.FindingPath(finding))]
+				if !exists {
+					ref = assetRef(asset.AssetIdentifier)
+				}
+
+				rating := cdxRating{Severity: cycloneDXSeverities[finding.Severity], Score: finding.CVSSScore}
+				if rating.Severity == "" {
+					rating.Severity = "unknown"
+				}
+				if finding.CVSSVector != "" {
+					rating.Method = "CVSSv3"
+					if strings.HasPrefix(finding.CVSSVector, "CVSS:3.1") {
+						rating.Method = "CVSSv31"
+					}
+					rating.Vector = finding.CVSSVector
+				}
+
+				vuln := cdxVulnerability{
+					BOMRef:      fmt.Sprintf("vulnerability-%d", len(bom.Vulnerabilities)+1),
+					ID:          finding.Name,
+					Ratings:     []cdxRating{rating},
+					Description: finding.Description,
+					Analysis:    findingAnalysis(finding),
+					Affects:     []cdxAffect{{Ref: ref}},
+				}
+				if finding.ExternalFindingLink != "" {
+					vuln.Source = &cdxSource{Name: finding.Source, URL: finding.ExternalFindingLink}
+				}
+				if finding.FixedVersion != "" {
+					vuln.Recommendation = fmt.Sprintf("Upgrade %s to version %s or later", finding.DetailedName, finding.FixedVersion)
+				}
+
+				bom.Vulnerabilities = append(bom.Vulnerabilities, vuln)
+			}
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(bom)
+}
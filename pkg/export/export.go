@@ -0,0 +1,87 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"scanapp/pkg/vulnerability"
+	"strings"
+)
+
+// Options carries the run context included in the exports
+type Options struct {
+	ToolVersion string                        // Version of scanapp
+	RunID       string                        // Run the state belongs to, if known
+	Inventory   []vulnerability.InventoryItem // Package inventory of the run, required by the SBOM formats
+	Host        string                        // Host the scan ran on
+	StartedAt   string                        // When the scan started, if known
+	CompletedAt string                        // When the scan completed, if known
+	Columns     []string                      // CSV columns, DefaultColumns when empty
+}
+
+// OptionsForRun returns the options carrying the context of a stored run
+func OptionsForRun(toolVersion string, metadata vulnerability.RunMetadata) Options {
+	return Options{
+		ToolVersion: toolVersion,
+		RunID:       metadata.RunID,
+		Host:        metadata.Host,
+		StartedAt:   metadata.StartedAt,
+		CompletedAt: metadata.CompletedAt,
+	}
+}
+
+// Formats lists the supported export formats
+var Formats = []string{"sarif", "cyclonedx", "spdx", "csv", "jsonl"}
+
+// IsSBOMFormat reports whether the format needs the package inventory of the run
+func IsSBOMFormat(format string) bool {
+	return format == "cyclonedx" || format == "spdx"
+}
+
+// Write exports the state in the given format
+func Write(w io.Writer, format string, state *vulnerability.VulnerabilityOutput, opts Options) error {
+	if IsSBOMFormat(format) && opts.Inventory == nil {
+		return fmt.Errorf("%s export needs the package inventory of the run, which is missing", format)
+	}
+
+	switch format {
+	case "sarif":
+		return WriteSARIF(w, state, opts)
+	case "cyclonedx":
+		return WriteCycloneDX(w, state, opts)
+	case "spdx":
+		return WriteSPDX(w, opts)
+	case "csv":
+		return WriteCSV(w, state, opts)
+	case "jsonl":
+		return WriteJSONLines(w, state, opts)
+	default:
+		return fmt.Errorf("unsupported export format '%s', supported formats are %v", format, Formats)
+	}
+}
+
+// suppressionJustification describes an accepted risk with the owner and expiry date it carries
+func suppressionJustification(suppression *vulnerability.SuppressionInfo) string {
+	var details []string
+	if suppression.Owner != "" {
+		details = append(details, "owner "+suppression.Owner)
+	}
+	if suppression.Expires != "" {
+		details = append(details, "expires "+suppression.Expires)
+	}
+	if len(details) == 0 {
+		return suppression.Justification
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s (%s)", suppression.Justification, strings.Join(details, ", ")))
+}
+
+// assetName names an asset in the SBOMs. A host without a provider ID is named after its hostname,
+// as both formats require a name.
+func assetName(asset vulnerability.AssetIdentifier, host string) string {
+	if asset.ProviderId != "" {
+		return asset.ProviderId
+	}
+	if host != "" {
+		return host
+	}
+	return "unidentified-host"
+}
@@ -0,0 +1,147 @@
+package export
+
+import (
+	"fmt"
+	"path/filepath"
+	"scanapp/pkg/vulnerability"
+	"strings"
+)
+
+// purlEcosystems maps path fragments of library manifests to package URL types, checked in order
+var purlEcosystems = []struct {
+	fragment string
+	purlType string
+}{
+	{"node_modules", "npm"},
+	{"package.json", "npm"},
+	{"package-lock.json", "npm"},
+	{"yarn.lock", "npm"},
+	{"pnpm-lock.yaml", "npm"},
+	{".jar", "maven"},
+	{".war", "maven"},
+	{".ear", "maven"},
+	{"pom.xml", "maven"},
+	{"pom.properties", "maven"},
+	{"site-packages", "pypi"},
+	{"dist-packages", "pypi"},
+	{".dist-info", "pypi"},
+	{".egg-info", "pypi"},
+	{"requirements.txt", "pypi"},
+	{"pipfile.lock", "pypi"},
+	{"poetry.lock", "pypi"},
+	{"gemfile.lock", "gem"},
+	{".gemspec", "gem"},
+	{".nupkg", "nuget"},
+	{".nuspec", "nuget"},
+	{".deps.json", "nuget"},
+	{"packages.config", "nuget"},
+	{"cargo.lock", "cargo"},
+	{"composer.lock", "composer"},
+	{"go.mod", "golang"},
+	{"go.sum", "golang"},
+}
+
+// purlType returns the package URL type of an inventory item
+func purlType(item vulnerability.InventoryItem) string {
+	path := strings.ToLower(filepath.ToSlash(item.Path))
+	method := strings.ToLower(item.DetectionMethod)
+
+	switch item.Section {
+	case "osPackages":
+		if osType, _ := vulnerability.OSPackageDatabase(item.Path); osType != "" {
+			return osType
+		}
+	case "libraries":
+		for _, ecosystem := range purlEcosystems {
+			if strings.Contains(path, ecosystem.fragment) {
+				return ecosystem.purlType
+			}
+		}
+		// Go modules are named after their import path
+		if strings.Contains(method, "go") || strings.HasPrefix(item.Name, "github.com/") || strings.HasPrefix(item.Name, "golang.org/") {
+			return "golang"
+		}
+	}
+	return "generic"
+}
+
+// escapePURL percent-encodes everything except the characters a package URL leaves unencoded
+func escapePURL(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// isCPE reports whether a package name is a CPE rather than a package
+func isCPE(name string) bool {
+	return strings.HasPrefix(name, "cpe:")
+}
+
+// distroNamespaces maps os-release IDs to the package URL namespace of their vendor where they differ
+var distroNamespaces = map[string]string{
+	"rhel": "redhat",
+}
+
+// PackageURL returns the package URL of an inventory item. It is empty for CPEs and for OS packages
+// whose distribution is unknown, as their package URL needs it as namespace.
+func PackageURL(item vulnerability.InventoryItem) string {
+	if isCPE(item.Name) {
+		return ""
+	}
+
+	purlType := purlType(item)
+	namespace, name, qualifiers := "", item.Name, ""
+
+	switch purlType {
+	case "deb", "rpm", "apk":
+		if item.OSRelease == nil {
+			return ""
+		}
+		namespace = item.OSRelease.ID
+		if vendor, exists := distroNamespaces[namespace]; exists {
+			namespace = vendor
+		}
+		if item.OSRelease.VersionID != "" {
+			qualifiers = "?distro=" + escapePURL(item.OSRelease.ID+"-"+item.OSRelease.VersionID)
+		}
+	case "maven":
+		// Maven packages are named group:artifact
+		if i := strings.LastIndex(name, ":"); i != -1 {
+			namespace, name = name[:i], name[i+1:]
+		}
+	case "npm":
+		// Scoped packages are named @scope/name
+		if strings.HasPrefix(name, "@") {
+			if i := strings.Index(name, "/"); i != -1 {
+				namespace, name = name[:i], name[i+1:]
+			}
+		}
+	case "golang":
+		if i := strings.LastIndex(name, "/"); i != -1 {
+			namespace, name = name[:i], name[i+1:]
+		}
+	case "pypi":
+		// PyPI names are normalized to lower case with dashes
+		name = strings.ReplaceAll(strings.ToLower(name), "_", "-")
+	}
+
+	purl := "pkg:" + purlType + "/"
+	if namespace != "" {
+		var segments []string
+		for _, segment := range strings.Split(namespace, "/") {
+			segments = append(segments, escapePURL(segment))
+		}
+		purl += strings.Join(segments, "/") + "/"
+	}
+	purl += escapePURL(name)
+	if item.Version != "" {
+		purl += "@" + escapePURL(item.Version)
+	}
+	return purl + qualifiers
+}
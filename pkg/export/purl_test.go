@@ -0,0 +1,82 @@
+package export
+
+import (
+	"scanapp/pkg/vulnerability"
+	"testing"
+)
+
+func TestPackageURL(t *testing.T) {
+	debian := &vulnerability.OSRelease{ID: "debian", VersionID: "12"}
+	rhel := &vulnerability.OSRelease{ID: "rhel", VersionID: "9.3"}
+	alpine := &vulnerability.OSRelease{ID: "alpine", VersionID: "3.19.1"}
+
+	tests := []struct {
+		name string
+		item vulnerability.InventoryItem
+		want string
+	}{
+		{
+			name: "deb with distro",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "curl", Version: "7.88.1-10+deb12u5", Path: "/var/lib/dpkg/status", OSRelease: debian},
+			want: "pkg:deb/debian/curl@7.88.1-10%2Bdeb12u5?distro=debian-12",
+		},
+		{
+			name: "deb in a mounted file system",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "openssl", Version: "3.0.11-1", Path: "/mnt/web-1/var/lib/dpkg/status", OSRelease: debian},
+			want: "pkg:deb/debian/openssl@3.0.11-1?distro=debian-12",
+		},
+		{
+			name: "deb with a path relative to the scanned directory",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "bash", Version: "5.2.15-2", Path: "var/lib/dpkg/status", OSRelease: debian},
+			want: "pkg:deb/debian/bash@5.2.15-2?distro=debian-12",
+		},
+		{
+			name: "deb with unknown distro",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "curl", Version: "7.88.1", Path: "/var/lib/dpkg/status"},
+			want: "",
+		},
+		{
+			name: "rpm of RHEL uses the vendor namespace",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "glibc", Version: "2.34-83.el9", Path: "/var/lib/rpm/rpmdb.sqlite", OSRelease: rhel},
+			want: "pkg:rpm/redhat/glibc@2.34-83.el9?distro=rhel-9.3",
+		},
+		{
+			name: "rpm in the sysimage database",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "zlib", Version: "1.2.13", Path: "/usr/lib/sysimage/rpm/rpmdb.sqlite", OSRelease: &vulnerability.OSRelease{ID: "opensuse-leap"}},
+			want: "pkg:rpm/opensuse-leap/zlib@1.2.13",
+		},
+		{
+			name: "apk",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "musl", Version: "1.2.4-r2", Path: "/lib/apk/db/installed", OSRelease: alpine},
+			want: "pkg:apk/alpine/musl@1.2.4-r2?distro=alpine-3.19.1",
+		},
+		{
+			name: "path merely containing apk is not an apk database",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "tool", Version: "1.0", Path: "/opt/apkeep/lib/tool", OSRelease: alpine},
+			want: "pkg:generic/tool@1.0",
+		},
+		{
+			name: "path merely containing rpm is not an rpm database",
+			item: vulnerability.InventoryItem{Section: "osPackages", Name: "tool", Version: "1.0", Path: "/home/rpmbuild/tool", OSRelease: rhel},
+			want: "pkg:generic/tool@1.0",
+		},
+		{
+			name: "npm scoped package",
+			item: vulnerability.InventoryItem{Section: "libraries", Name: "@babel/core", Version: "7.23.0", Path: "/app/node_modules/@babel/core/package.json"},
+			want: "pkg:npm/%40babel/core@7.23.0",
+		},
+		{
+			name: "cpe",
+			item: vulnerability.InventoryItem{Section: "cpes", Name: "cpe:2.3:a:nginx:nginx:1.25.3:*:*:*:*:*:*:*", Version: "1.25.3"},
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := PackageURL(test.item); got != test.want {
+				t.Errorf("PackageURL() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
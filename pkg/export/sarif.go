@@ -0,0 +1,233 @@
+// Package export writes scan results in formats consumed by other tools
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"scanapp/pkg/vulnerability"
+	"strings"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	// sarifRootBase is the URI base the finding paths are relative to
+	sarifRootBase = "ROOT"
+)
+
+// sarifLevels maps Wiz severities to SARIF result levels
+var sarifLevels = map[string]string{
+	"Critical": "error",
+	"High":     "error",
+	"Medium":   "warning",
+	"Low":      "note",
+	"None":     "none",
+}
+
+// sarifSecuritySeverities is the security-severity used when a finding has no CVSS score
+var sarifSecuritySeverities = map[string]float64{
+	"Critical": 9.5,
+	"High":     8.0,
+	"Medium":   5.5,
+	"Low":      2.0,
+	"None":     0.0,
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds"`
+	Results            []sarifResult                    `json:"results"`
+	Properties         map[string]interface{}           `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	ID                   string                 `json:"id"`
+	Name                 string                 `json:"name"`
+	ShortDescription     sarifMessage           `json:"shortDescription"`
+	HelpURI              string                 `json:"helpUri,omitempty"`
+	DefaultConfiguration sarifConfiguration     `json:"defaultConfiguration"`
+	Properties           map[string]interface{} `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifResult struct {
+	RuleID              string                 `json:"ruleId"`
+	RuleIndex           int                    `json:"ruleIndex"`
+	Level               string                 `json:"level"`
+	Message             sarifMessage           `json:"message"`
+	Locations           []sarifLocation        `json:"locations"`
+	PartialFingerprints map[string]string      `json:"partialFingerprints"`
+	Suppressions        []sarifSuppression     `json:"suppressions,omitempty"`
+	Properties          map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Status        string `json:"status"`
+	Justification string `json:"justification,omitempty"`
+}
+
+// WriteSARIF writes the findings of the state as a SARIF 2.1.0 log with one rule per vulnerability
+func WriteSARIF(w io.Writer, state *vulnerability.VulnerabilityOutput, opts Options) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "scanapp",
+			Version:        opts.ToolVersion,
+			InformationURI: "https://github.com/jtb75/scanapp",
+			Rules:          []sarifRule{},
+		}},
+		OriginalURIBaseIDs: map[string]sarifArtifactLocation{
+			sarifRootBase: {URI: "file:///"},
+		},
+		Results: []sarifResult{},
+	}
+	if opts.RunID != "" {
+		run.Properties = map[string]interface{}{"runId": opts.RunID}
+	}
+
+	ruleIndex := make(map[string]int)
+
+	for _, dataSource := range state.DataSources {
+		for _, asset := range dataSource.Assets {
+			for _, finding := range asset.VulnerabilityFindings {
+				level := sarifLevels[finding.Severity]
+				if level == "" {
+					level = "none"
+				}
+
+				securitySeverity := finding.CVSSScore
+				if securitySeverity == 0 {
+					securitySeverity = sarifSecuritySeverities[finding.Severity]
+				}
+
+				// One rule per vulnerability, using the highest severity seen for it
+				index, exists := ruleIndex[finding.Name]
+				if !exists {
+					index = len(run.Tool.Driver.Rules)
+					ruleIndex[finding.Name] = index
+					run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+						ID:                   finding.Name,
+						Name:                 finding.Name,
+						ShortDescription:     sarifMessage{Text: fmt.Sprintf("Vulnerability %s", finding.Name)},
+						HelpURI:              finding.ExternalFindingLink,
+						DefaultConfiguration: sarifConfiguration{Level: level},
+						Properties: map[string]interface{}{
+							"security-severity": fmt.Sprintf("%.1f", securitySeverity),
+							"tags":              []string{"security", "vulnerability"},
+						},
+					})
+				} else if current := run.Tool.Driver.Rules[index]; securitySeverity > parseSeverity(current.Properties["security-severity"]) {
+					current.Properties["security-severity"] = fmt.Sprintf("%.1f", securitySeverity)
+					current.DefaultConfiguration.Level = level
+					run.Tool.Driver.Rules[index] = current
+				}
+
+				message := fmt.Sprintf("%s %s is vulnerable to %s (%s).", finding.DetailedName, finding.Version, finding.Name, finding.Severity)
+				if finding.FixedVersion != "" {
+					message += fmt.Sprintf(" Fixed in version %s.", finding.FixedVersion)
+				}
+
+				path := vulnerability.FindingPath(finding)
+				result := sarifResult{
+					RuleID:    finding.Name,
+					RuleIndex: index,
+					Level:     level,
+					Message:   sarifMessage{Text: message},
+					Locations: []sarifLocation{{
+						PhysicalLocation: sarifPhysicalLocation{
+							ArtifactLocation: sarifArtifactLocation{URI: strings.TrimPrefix(path, "/"), URIBaseID: sarifRootBase},
+						},
+						LogicalLocations: []sarifLogicalLocation{{Name: asset.AssetIdentifier.ProviderId, Kind: "asset"}},
+					}},
+					PartialFingerprints: map[string]string{
+						"scanappFinding/v1": strings.Join([]string{asset.AssetIdentifier.ProviderId, finding.DetailedName, path, finding.Name}, "|"),
+					},
+					Properties: map[string]interface{}{
+						"package":      finding.DetailedName,
+						"version":      finding.Version,
+						"fixedVersion": finding.FixedVersion,
+					},
+				}
+
+				// Accepted risks and VEX statements become SARIF suppressions
+				if finding.Suppression != nil {
+					result.Suppressions = append(result.Suppressions, sarifSuppression{
+						Kind:          "external",
+						Status:        "accepted",
+						Justification: suppressionJustification(finding.Suppression),
+					})
+				}
+				if finding.VEX.Excluded() {
+					result.Suppressions = append(result.Suppressions, sarifSuppression{
+						Kind:          "external",
+						Status:        "accepted",
+						Justification: strings.TrimSpace(fmt.Sprintf("VEX %s %s %s", finding.VEX.Status, finding.VEX.Justification, finding.VEX.Detail)),
+					})
+				}
+
+				run.Results = append(run.Results, result)
+			}
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []sarifRun{run},
+	})
+}
+
+// parseSeverity reads back a security-severity property
+func parseSeverity(value interface{}) float64 {
+	var severity float64
+	if text, ok := value.(string); ok {
+		fmt.Sscanf(text, "%f", &severity)
+	}
+	return severity
+}
@@ -0,0 +1,64 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"scanapp/pkg/vulnerability"
+	"testing"
+)
+
+// hostlessInventory returns the inventory of a host without a provider ID
+func hostlessInventory() []vulnerability.InventoryItem {
+	return []vulnerability.InventoryItem{
+		{Asset: vulnerability.AssetIdentifier{CloudPlatform: "AWS"}, Section: "libraries", Name: "lodash", Version: "4.17.21", Path: "/app/node_modules/lodash/package.json"},
+	}
+}
+
+func TestSBOMsNameHostWithoutIdentity(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "jump-box-1", want: "jump-box-1"},
+		{host: "", want: "unidentified-host"},
+	}
+
+	for _, test := range tests {
+		opts := Options{Host: test.host, Inventory: hostlessInventory()}
+
+		var cycloneDX bytes.Buffer
+		if err := WriteCycloneDX(&cycloneDX, &vulnerability.VulnerabilityOutput{}, opts); err != nil {
+			t.Fatalf("WriteCycloneDX returned error: %v", err)
+		}
+		var bom struct {
+			Components []struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"components"`
+		}
+		if err := json.Unmarshal(cycloneDX.Bytes(), &bom); err != nil {
+			t.Fatal(err)
+		}
+		for _, component := range bom.Components {
+			if component.Type == "device" && component.Name != test.want {
+				t.Errorf("CycloneDX asset named %q, want %q", component.Name, test.want)
+			}
+		}
+
+		var spdx bytes.Buffer
+		if err := WriteSPDX(&spdx, opts); err != nil {
+			t.Fatalf("WriteSPDX returned error: %v", err)
+		}
+		var document struct {
+			Packages []struct {
+				Name string `json:"name"`
+			} `json:"packages"`
+		}
+		if err := json.Unmarshal(spdx.Bytes(), &document); err != nil {
+			t.Fatal(err)
+		}
+		if len(document.Packages) == 0 || document.Packages[0].Name != test.want {
+			t.Errorf("SPDX packages = %+v, want the asset named %q first", document.Packages, test.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"scanapp/pkg/vulnerability"
+	"time"
+)
+
+const spdxVersion = "SPDX-2.3"
+
+// spdxInvalidID matches the characters not allowed in SPDX identifiers
+var spdxInvalidID = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxID returns a valid SPDX identifier built from the parts
+func spdxID(parts ...interface{}) string {
+	return "SPDXRef-" + spdxInvalidID.ReplaceAllString(fmt.Sprint(parts...), "-")
+}
+
+// WriteSPDX writes the inventory as an SPDX 2.3 document. SPDX has no place for vulnerabilities,
+// use the CycloneDX or SARIF exports for those.
+func WriteSPDX(w io.Writer, opts Options) error {
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+
+	name := "scanapp"
+	if opts.RunID != "" {
+		name += "-" + opts.RunID
+	}
+
+	creator := "Tool: scanapp"
+	if opts.ToolVersion != "" {
+		creator += "-" + opts.ToolVersion
+	}
+
+	document := spdxDocument{
+		SPDXVersion:       spdxVersion,
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: "https://github.com/jtb75/scanapp/spdx/" + name + "-" + serialNumber[len("urn:uuid:"):],
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{creator},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+
+	// Every asset is a package the document describes and that contains the packages found on it
+	assetIDs := make(map[string]string)
+	for i, item := range opts.Inventory {
+		key := vulnerability.AssetKey(item.Asset)
+		assetID, exists := assetIDs[key]
+		if !exists {
+			assetID = spdxID("Asset-", len(assetIDs)+1)
+			assetIDs[key] = assetID
+			document.Packages = append(document.Packages, spdxPackage{
+				SPDXID:           assetID,
+				Name:             assetName(item.Asset, opts.Host),
+				DownloadLocation: "NOASSERTION",
+				PrimaryPurpose:   "DEVICE",
+				SourceInfo:       "cloud platform " + item.Asset.CloudPlatform,
+			})
+			document.Relationships = append(document.Relationships, spdxRelationship{
+				SPDXElementID:      document.SPDXID,
+				RelationshipType:   "DESCRIBES",
+				RelatedSPDXElement: assetID,
+			})
+		}
+
+		pkg := spdxPackage{
+			SPDXID:           spdxID("Package-", i+1),
+			Name:             item.Name,
+			VersionInfo:      item.Version,
+			DownloadLocation: "NOASSERTION",
+			PrimaryPurpose:   "LIBRARY",
+		}
+		if item.Section == "applications" {
+			pkg.PrimaryPurpose = "APPLICATION"
+		}
+		if item.Path != "" {
+			pkg.SourceInfo = "found at " + item.Path
+		}
+		if purl := PackageURL(item); purl != "" {
+			pkg.ExternalRefs = append(pkg.ExternalRefs, spdxExternalRef{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  purl,
+			})
+		}
+		if isCPE(item.Name) {
+			referenceType := "cpe23Type"
+			if len(item.Name) > 4 && item.Name[4] == '/' {
+				referenceType = "cpe22Type"
+			}
+			pkg.ExternalRefs = append(pkg.ExternalRefs, spdxExternalRef{
+				ReferenceCategory: "SECURITY",
+				ReferenceType:     referenceType,
+				ReferenceLocator:  item.Name,
+			})
+		}
+
+		document.Packages = append(document.Packages, pkg)
+		document.Relationships = append(document.Relationships, spdxRelationship{
+			SPDXElementID:      assetID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: pkg.SPDXID,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
@@ -0,0 +1,187 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"scanapp/pkg/vulnerability"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// findingRow is a finding together with the context it was found in
+type findingRow struct {
+	opts         Options
+	asset        vulnerability.AssetIdentifier
+	analysisDate string
+	finding      vulnerability.VulnerabilityFinding
+}
+
+// findingStatus describes whether a finding is open, suppressed or covered by a VEX statement
+func findingStatus(finding vulnerability.VulnerabilityFinding) string {
+	switch {
+	case finding.Suppression != nil:
+		return "suppressed"
+	case finding.VEX != nil:
+		return "vex:" + finding.VEX.Status
+	default:
+		return "open"
+	}
+}
+
+// formatFloat formats a score, leaving it empty when it is not set
+func formatFloat(value float64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatBool formats a flag for the CSV export, leaving it empty when it was never set
+func formatBool(value *bool) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*value)
+}
+
+// csvColumns maps the CSV column names to the value they hold
+var csvColumns = map[string]func(row findingRow) string{
+	"runId":               func(row findingRow) string { return row.opts.RunID },
+	"host":                func(row findingRow) string { return row.opts.Host },
+	"cloudPlatform":       func(row findingRow) string { return row.asset.CloudPlatform },
+	"providerId":          func(row findingRow) string { return row.asset.ProviderId },
+	"id":                  func(row findingRow) string { return row.finding.ID },
+	"cve":                 func(row findingRow) string { return row.finding.Name },
+	"severity":            func(row findingRow) string { return row.finding.Severity },
+	"package":             func(row findingRow) string { return row.finding.DetailedName },
+	"version":             func(row findingRow) string { return row.finding.Version },
+	"fixedVersion":        func(row findingRow) string { return row.finding.FixedVersion },
+	"path":                func(row findingRow) string { return vulnerability.FindingPath(row.finding) },
+	"detectionSource":     func(row findingRow) string { return row.finding.ExternalDetectionSource },
+	"source":              func(row findingRow) string { return row.finding.Source },
+	"link":                func(row findingRow) string { return row.finding.ExternalFindingLink },
+	"cvssScore":           func(row findingRow) string { return formatFloat(row.finding.CVSSScore) },
+	"cvssVector":          func(row findingRow) string { return row.finding.CVSSVector },
+	"exploitabilityScore": func(row findingRow) string { return formatFloat(row.finding.ExploitabilityScore) },
+	"epssProbability":     func(row findingRow) string { return formatFloat(row.finding.EPSSProbability) },
+	"epssPercentile":      func(row findingRow) string { return formatFloat(row.finding.EPSSPercentile) },
+	"knownExploited":      func(row findingRow) string { return formatBool(row.finding.KnownExploited) },
+	"kevDateAdded":        func(row findingRow) string { return row.finding.KEVDateAdded },
+	"firstSeen":           func(row findingRow) string { return row.finding.FirstSeen },
+	"analysisDate":        func(row findingRow) string { return row.analysisDate },
+	"scanStartedAt":       func(row findingRow) string { return row.opts.StartedAt },
+	"scanCompletedAt":     func(row findingRow) string { return row.opts.CompletedAt },
+	"status":              func(row findingRow) string { return findingStatus(row.finding) },
+	"justification": func(row findingRow) string {
+		if row.finding.Suppression != nil {
+			return row.finding.Suppression.Justification
+		}
+		if row.finding.VEX != nil {
+			return strings.TrimSpace(row.finding.VEX.Justification + " " + row.finding.VEX.Detail)
+		}
+		return ""
+	},
+	"description": func(row findingRow) string { return row.finding.Description },
+}
+
+// DefaultColumns are the CSV columns written when none are configured
+var DefaultColumns = []string{
+	"runId", "host", "cloudPlatform", "providerId", "cve", "severity", "package", "version",
+	"fixedVersion", "path", "cvssScore", "epssProbability", "knownExploited", "firstSeen", "status",
+}
+
+// ValidateColumns checks that every column name is known
+func ValidateColumns(columns []string) error {
+	for _, column := range columns {
+		if _, exists := csvColumns[column]; !exists {
+			var known []string
+			for name := range csvColumns {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown export column '%s', known columns are %s", column, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// eachFinding calls fn for every finding of the state with its context
+func eachFinding(state *vulnerability.VulnerabilityOutput, opts Options, fn func(row findingRow) error) error {
+	for _, dataSource := range state.DataSources {
+		for _, asset := range dataSource.Assets {
+			for _, finding := range asset.VulnerabilityFindings {
+				if err := fn(findingRow{opts: opts, asset: asset.AssetIdentifier, analysisDate: dataSource.AnalysisDate, finding: finding}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes one line per finding with the configured columns, or DefaultColumns when none are set
+func WriteCSV(w io.Writer, state *vulnerability.VulnerabilityOutput, opts Options) error {
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = DefaultColumns
+	}
+	if err := ValidateColumns(columns); err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	err := eachFinding(state, opts, func(row findingRow) error {
+		for i, column := range columns {
+			record[i] = csvColumns[column](row)
+		}
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// jsonLinesFinding is a finding with the context of the run, written as one JSON object per line
+type jsonLinesFinding struct {
+	RunID           string                        `json:"runId,omitempty"`
+	Host            string                        `json:"host,omitempty"`
+	Asset           vulnerability.AssetIdentifier `json:"asset"`
+	Status          string                        `json:"status"`
+	AnalysisDate    string                        `json:"analysisDate,omitempty"`
+	ScanStartedAt   string                        `json:"scanStartedAt,omitempty"`
+	ScanCompletedAt string                        `json:"scanCompletedAt,omitempty"`
+	ExportedAt      string                        `json:"exportedAt"`
+	vulnerability.VulnerabilityFinding
+}
+
+// WriteJSONLines writes one JSON object per finding, suitable for log pipelines
+func WriteJSONLines(w io.Writer, state *vulnerability.VulnerabilityOutput, opts Options) error {
+	encoder := json.NewEncoder(w)
+	exportedAt := time.Now().UTC().Format(time.RFC3339)
+
+	return eachFinding(state, opts, func(row findingRow) error {
+		return encoder.Encode(jsonLinesFinding{
+			RunID:                row.opts.RunID,
+			Host:                 row.opts.Host,
+			Asset:                row.asset,
+			Status:               findingStatus(row.finding),
+			AnalysisDate:         row.analysisDate,
+			ScanStartedAt:        row.opts.StartedAt,
+			ScanCompletedAt:      row.opts.CompletedAt,
+			ExportedAt:           exportedAt,
+			VulnerabilityFinding: row.finding,
+		})
+	})
+}
@@ -0,0 +1,50 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"scanapp/pkg/vulnerability"
+	"testing"
+)
+
+func TestCSVLeavesKnownExploitedEmptyWithoutKEVData(t *testing.T) {
+	exploited, notExploited := true, false
+	state := &vulnerability.VulnerabilityOutput{
+		DataSources: []vulnerability.DataSource{{
+			Assets: []vulnerability.Asset{{
+				AssetIdentifier: vulnerability.AssetIdentifier{CloudPlatform: "AWS", ProviderId: "i-0123"},
+				VulnerabilityFindings: []vulnerability.VulnerabilityFinding{
+					{Name: "CVE-2021-44228", KnownExploited: &exploited, CVSSScore: 10},
+					{Name: "CVE-2023-0001", KnownExploited: &notExploited},
+					{Name: "CVE-2023-0002"},
+				},
+			}},
+		}},
+	}
+
+	var output bytes.Buffer
+	if err := WriteCSV(&output, state, Options{Columns: []string{"cve", "knownExploited", "cvssScore"}}); err != nil {
+		t.Fatalf("WriteCSV returned error: %v", err)
+	}
+	records, err := csv.NewReader(&output).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"cve", "knownExploited", "cvssScore"},
+		{"CVE-2021-44228", "true", "10"},
+		{"CVE-2023-0001", "false", ""},
+		{"CVE-2023-0002", "", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("records = %v, want %v", records, want)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if records[i][j] != want[i][j] {
+				t.Errorf("row %d column %s = %q, want %q", i, want[0][j], records[i][j], want[i][j])
+			}
+		}
+	}
+}
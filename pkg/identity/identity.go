@@ -0,0 +1,246 @@
+// Package identity discovers the cloud identity of the host from the instance metadata services
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"scanapp/pkg/config"
+	"strings"
+	"time"
+)
+
+// Default base URLs of the instance metadata services
+const (
+	DefaultAWSEndpoint   = "http://169.254.169.254"
+	DefaultAzureEndpoint = "http://169.254.169.254"
+	DefaultGCPEndpoint   = "http://metadata.google.internal"
+)
+
+// defaultTimeout bounds every metadata request, the services answer within milliseconds when present
+const defaultTimeout = 2 * time.Second
+
+// Identity is the cloud identity of the host, in the form Wiz uses for virtual machines
+type Identity struct {
+	CloudPlatform  string // AWS, Azure or GCP
+	ProviderID     string // Instance ARN, Azure resource ID or GCP resource name
+	SubscriptionID string // AWS account, Azure subscription or GCP project
+	Region         string
+}
+
+// Endpoints are the base URLs of the metadata services, empty ones are skipped
+type Endpoints struct {
+	AWS   string
+	Azure string
+	GCP   string
+}
+
+// DefaultEndpoints returns the well-known metadata service addresses
+func DefaultEndpoints() Endpoints {
+	return Endpoints{AWS: DefaultAWSEndpoint, Azure: DefaultAzureEndpoint, GCP: DefaultGCPEndpoint}
+}
+
+// Discoverer queries the metadata services
+type Discoverer struct {
+	Client    *http.Client
+	Endpoints Endpoints
+}
+
+// NewDiscoverer returns a discoverer for the given endpoints. Metadata services are link-local,
+// so its client never uses a proxy.
+func NewDiscoverer(endpoints Endpoints) *Discoverer {
+	return &Discoverer{
+		Client: &http.Client{
+			Transport: &http.Transport{Proxy: nil},
+			Timeout:   defaultTimeout,
+		},
+		Endpoints: endpoints,
+	}
+}
+
+// Discover tries AWS, Azure and GCP in turn and returns the first identity found
+func (d *Discoverer) Discover(ctx context.Context) (*Identity, error) {
+	var failures []string
+	for _, provider := range []struct {
+		name     string
+		endpoint string
+		discover func(context.Context) (*Identity, error)
+	}{
+		{"AWS", d.Endpoints.AWS, d.AWS},
+		{"Azure", d.Endpoints.Azure, d.Azure},
+		{"GCP", d.Endpoints.GCP, d.GCP},
+	} {
+		if provider.endpoint == "" {
+			continue
+		}
+		identity, err := provider.discover(ctx)
+		if err == nil {
+			return identity, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", provider.name, err))
+	}
+	return nil, fmt.Errorf("no cloud metadata service found (%s)", strings.Join(failures, "; "))
+}
+
+// get requests a metadata URL with the given headers and returns the body
+func (d *Discoverer) get(ctx context.Context, method, url string, headers map[string]string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s returned %s", method, url, response.Status)
+	}
+	return body, nil
+}
+
+// awsPartition returns the ARN partition of an AWS region
+func awsPartition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	default:
+		return "aws"
+	}
+}
+
+// AWS reads the instance identity document using IMDSv2
+func (d *Discoverer) AWS(ctx context.Context) (*Identity, error) {
+	base := strings.TrimSuffix(d.Endpoints.AWS, "/")
+
+	// IMDSv2 requires a session token
+	token, err := d.get(ctx, "PUT", base+"/latest/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "60",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get IMDSv2 token: %v", err)
+	}
+
+	body, err := d.get(ctx, "GET", base+"/latest/dynamic/instance-identity/document", map[string]string{
+		"X-aws-ec2-metadata-token": string(token),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		InstanceID string `json:"instanceId"`
+		AccountID  string `json:"accountId"`
+		Region     string `json:"region"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("cannot parse instance identity document: %v", err)
+	}
+	if document.InstanceID == "" || document.AccountID == "" || document.Region == "" {
+		return nil, fmt.Errorf("incomplete instance identity document")
+	}
+
+	return &Identity{
+		CloudPlatform:  "AWS",
+		ProviderID:     fmt.Sprintf("arn:%s:ec2:%s:%s:instance/%s", awsPartition(document.Region), document.Region, document.AccountID, document.InstanceID),
+		SubscriptionID: document.AccountID,
+		Region:         document.Region,
+	}, nil
+}
+
+// Azure reads the compute metadata of the Azure Instance Metadata Service
+func (d *Discoverer) Azure(ctx context.Context) (*Identity, error) {
+	body, err := d.get(ctx, "GET", strings.TrimSuffix(d.Endpoints.Azure, "/")+"/metadata/instance/compute?api-version=2021-02-01", map[string]string{
+		"Metadata": "true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var compute struct {
+		ResourceID     string `json:"resourceId"`
+		SubscriptionID string `json:"subscriptionId"`
+		Location       string `json:"location"`
+	}
+	if err := json.Unmarshal(body, &compute); err != nil {
+		return nil, fmt.Errorf("cannot parse compute metadata: %v", err)
+	}
+	if compute.ResourceID == "" || compute.SubscriptionID == "" {
+		return nil, fmt.Errorf("incomplete compute metadata")
+	}
+
+	return &Identity{
+		CloudPlatform:  "Azure",
+		ProviderID:     compute.ResourceID,
+		SubscriptionID: compute.SubscriptionID,
+		Region:         compute.Location,
+	}, nil
+}
+
+// GCP reads the project and instance from the Compute Engine metadata server
+func (d *Discoverer) GCP(ctx context.Context) (*Identity, error) {
+	base := strings.TrimSuffix(d.Endpoints.GCP, "/") + "/computeMetadata/v1/"
+	headers := map[string]string{"Metadata-Flavor": "Google"}
+
+	values := make(map[string]string)
+	for _, key := range []string{"project/project-id", "instance/zone", "instance/name"} {
+		body, err := d.get(ctx, "GET", base+key, headers)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = strings.TrimSpace(string(body))
+	}
+
+	// The zone is returned as projects/<number>/zones/<zone>
+	project, zone, name := values["project/project-id"], path.Base(values["instance/zone"]), values["instance/name"]
+	if project == "" || zone == "" || name == "" {
+		return nil, fmt.Errorf("incomplete instance metadata")
+	}
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i != -1 {
+		region = zone[:i]
+	}
+
+	return &Identity{
+		CloudPlatform:  "GCP",
+		ProviderID:     fmt.Sprintf("//compute.googleapis.com/projects/%s/zones/%s/instances/%s", project, zone, name),
+		SubscriptionID: project,
+		Region:         region,
+	}, nil
+}
+
+// Apply fills the scan identity fields of the configuration that are still empty and returns the
+// names of the fields it filled. Values set by the operator are never replaced.
+func Apply(cfg *config.Config, identity *Identity) []string {
+	var filled []string
+	for _, field := range []struct {
+		name   string
+		target *string
+		value  string
+	}{
+		{"scanCloudType", &cfg.ScanCloudType, identity.CloudPlatform},
+		{"scanProviderId", &cfg.ScanProviderID, identity.ProviderID},
+		{"scanSubscriptionId", &cfg.ScanSubscriptionID, identity.SubscriptionID},
+		{"scanRegion", &cfg.ScanRegion, identity.Region},
+	} {
+		if *field.target == "" && field.value != "" {
+			*field.target = field.value
+			filled = append(filled, field.name)
+		}
+	}
+	return filled
+}
@@ -0,0 +1,159 @@
+// Package policy evaluates a vulnerability policy over the findings of a scan
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"scanapp/pkg/config"
+	"scanapp/pkg/vulnerability"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Policy holds the rules a scan must pass. Suppressed findings and findings VEX marks as
+// not_affected or fixed are not evaluated.
+type Policy struct {
+	// Maximum number of findings allowed per severity, e.g. {"Critical": 0}
+	MaxFindings map[string]int `json:"maxFindings,omitempty"`
+	// Only count findings that have a fixed version for MaxFindings and MaxAgeDays
+	FixableOnly bool `json:"fixableOnly"`
+	// Maximum number of days a finding of a severity may stay open, measured from its first detection
+	MaxAgeDays map[string]int `json:"maxAgeDays,omitempty"`
+	// Findings in these packages (glob patterns) are ignored
+	AllowPackages []string `json:"allowPackages,omitempty"`
+	// Any finding in these packages (glob patterns) is a violation
+	DenyPackages []string `json:"denyPackages,omitempty"`
+	// Any finding of these CVEs is a violation
+	DenyCVEs []string `json:"denyCves,omitempty"`
+}
+
+// Violation describes a rule the scan did not pass
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(filePath string) (*Policy, error) {
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy file: %v", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(file, &policy); err != nil {
+		return nil, fmt.Errorf("cannot parse policy file: %v", err)
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate checks the severities and patterns used by the policy
+func (p *Policy) Validate() error {
+	for _, thresholds := range []map[string]int{p.MaxFindings, p.MaxAgeDays} {
+		for severity, limit := range thresholds {
+			if !config.IsWizSeverity(severity) {
+				return fmt.Errorf("policy severity must be one of %v, got '%s'", config.WizSeverities, severity)
+			}
+			if limit < 0 {
+				return fmt.Errorf("policy limit for %s cannot be negative", severity)
+			}
+		}
+	}
+	for _, pattern := range append(append([]string{}, p.AllowPackages...), p.DenyPackages...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid package pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether name matches one of the glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns a short description of a finding for violation messages
+func describe(asset vulnerability.AssetIdentifier, finding vulnerability.VulnerabilityFinding) string {
+	return fmt.Sprintf("%s in %s %s at %s (%s)", finding.Name, finding.DetailedName, finding.Version, vulnerability.FindingPath(finding), asset.ProviderId)
+}
+
+// Evaluate checks the findings of the state against the policy and returns the violations
+func (p *Policy) Evaluate(state *vulnerability.VulnerabilityOutput, now time.Time) []Violation {
+	var violations []Violation
+	counts := make(map[string]int)
+
+	deniedCVEs := make(map[string]bool)
+	for _, cve := range p.DenyCVEs {
+		deniedCVEs[strings.ToUpper(cve)] = true
+	}
+
+	for _, dataSource := range state.DataSources {
+		for _, asset := range dataSource.Assets {
+			for _, finding := range asset.VulnerabilityFindings {
+				// Accepted risks and VEX statements take findings out of the policy
+				if finding.Suppression != nil || finding.VEX.Excluded() {
+					continue
+				}
+				if matchesAny(p.AllowPackages, finding.DetailedName) {
+					continue
+				}
+
+				if deniedCVEs[strings.ToUpper(finding.Name)] {
+					violations = append(violations, Violation{Rule: "denyCves", Message: "denied CVE " + describe(asset.AssetIdentifier, finding)})
+				}
+				if matchesAny(p.DenyPackages, finding.DetailedName) {
+					violations = append(violations, Violation{Rule: "denyPackages", Message: "denied package " + describe(asset.AssetIdentifier, finding)})
+				}
+
+				if p.FixableOnly && finding.FixedVersion == "" {
+					continue
+				}
+				counts[finding.Severity]++
+
+				if maxAge, exists := p.MaxAgeDays[finding.Severity]; exists {
+					firstSeen, err := time.Parse(time.RFC3339, finding.FirstSeen)
+					if err == nil && now.Sub(firstSeen) > time.Duration(maxAge)*24*time.Hour {
+						violations = append(violations, Violation{
+							Rule:    "maxAgeDays",
+							Message: fmt.Sprintf("%s finding open for more than %d days since %s: %s", finding.Severity, maxAge, finding.FirstSeen, describe(asset.AssetIdentifier, finding)),
+						})
+					}
+				}
+			}
+		}
+	}
+
+	// Thresholds are checked in a stable order
+	var severities []string
+	for severity := range p.MaxFindings {
+		severities = append(severities, severity)
+	}
+	sort.Strings(severities)
+
+	for _, severity := range severities {
+		if counts[severity] > p.MaxFindings[severity] {
+			qualifier := ""
+			if p.FixableOnly {
+				qualifier = " fixable"
+			}
+			violations = append(violations, Violation{
+				Rule:    "maxFindings",
+				Message: fmt.Sprintf("%d%s %s findings, at most %d allowed", counts[severity], qualifier, severity, p.MaxFindings[severity]),
+			})
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,111 @@
+package report
+
+import (
+	"html/template"
+	"io"
+	"strings"
+)
+
+// htmlTemplate renders the report as a self-contained page, without external stylesheets or scripts
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Scan report {{.Run.RunID}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.25em; margin-top: 2em; border-bottom: 1px solid #ddd; padding-bottom: 0.2em; }
+table { border-collapse: collapse; margin: 0.5em 0; }
+th, td { border: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+td.num { text-align: right; }
+code { font-size: 0.9em; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.2em 1em; }
+dt { font-weight: bold; }
+.critical { color: #fff; background: #8b0000; }
+.high { color: #fff; background: #d9480f; }
+.medium { background: #ffd43b; }
+.low { background: #d3f9d8; }
+.none { background: #f1f3f5; }
+.failed { color: #c92a2a; font-weight: bold; }
+.ok { color: #2b8a3e; }
+.note { color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>Scan report {{.Run.RunID}}</h1>
+<dl>
+<dt>Started</dt><dd>{{.Run.StartedAt}}</dd>
+<dt>Completed</dt><dd>{{.Run.CompletedAt}}</dd>
+<dt>wizcli version</dt><dd>{{.Run.WizcliVersion}}</dd>
+<dt>Assets</dt><dd>{{join .Assets ", "}}</dd>
+{{- with .Run.SanityCheck}}{{if not .Passed}}
+<dt>Sanity check</dt><dd class="failed">failed{{if .Forced}}, forced{{else}}, run refused{{end}}: {{join .Reasons "; "}}</dd>
+{{- end}}{{end}}
+<dt>Report generated</dt><dd>{{.GeneratedAt}}</dd>
+</dl>
+
+<h2>Findings by severity</h2>
+<table>
+<tr><th>Severity</th><th>Open</th><th>Accepted</th></tr>
+{{- range .SeverityCounts}}
+<tr><td class="{{lower .Severity}}">{{.Severity}}</td><td class="num">{{.Open}}</td><td class="num">{{.Accepted}}</td></tr>
+{{- end}}
+<tr><th>Total</th><th>{{.TotalOpen}}</th><th>{{.TotalAccepted}}</th></tr>
+</table>
+<p class="note">Accepted findings are suppressed or marked as not affected or fixed by a VEX statement.</p>
+
+{{- with .Diff}}
+<h2>New findings since {{.From}}</h2>
+<p>New: {{.New}}, resolved: {{.Resolved}}, severity changed: {{.SeverityChanged}}, version changed: {{.VersionChanged}}</p>
+{{- if $.NewFindings}}
+{{template "findings" $.NewFindings}}
+{{- else}}
+<p>No new open findings.</p>
+{{- end}}
+{{- end}}
+
+<h2>Top packages</h2>
+{{- if .TopPackages}}
+<table>
+<tr><th>Package</th><th>Versions</th><th>Findings</th><th>Highest severity</th><th>Fixable</th></tr>
+{{- range .TopPackages}}
+<tr><td>{{.Package}}</td><td>{{join .Versions ", "}}</td><td class="num">{{.Findings}}</td><td class="{{lower .Highest}}">{{.Highest}}</td><td class="num">{{.Fixable}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No open findings.</p>
+{{- end}}
+
+<h2>Fixable findings</h2>
+{{- if .Fixable}}
+{{template "findings" .Fixable}}
+{{- else}}
+<p>No open finding has a fixed version.</p>
+{{- end}}
+
+<h2>Scanned directories</h2>
+<table>
+<tr><th>Directory</th><th>Status</th><th>Open findings</th></tr>
+{{- range .Targets}}
+<tr><td><code>{{.Directory}}</code></td>{{if .Succeeded}}<td class="ok">OK</td>{{else}}<td class="failed">Failed: {{.Error}}</td>{{end}}<td class="num">{{.Findings}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+{{define "findings"}}<table>
+<tr><th>Severity</th><th>Vulnerability</th><th>Package</th><th>Version</th><th>Fixed in</th><th>Path</th><th>Asset</th></tr>
+{{- range .}}
+<tr><td class="{{lower .Severity}}">{{.Severity}}</td><td>{{if .Link}}<a href="{{.Link}}">{{.Vulnerability}}</a>{{else}}{{.Vulnerability}}{{end}}</td><td>{{.Package}}</td><td>{{.Version}}</td><td>{{.FixedVersion}}</td><td><code>{{.Path}}</code></td><td>{{.Asset}}</td></tr>
+{{- end}}
+</table>{{end}}
+`))
+
+// WriteHTML renders the report as a self-contained HTML page
+func WriteHTML(w io.Writer, report *Report) error {
+	return htmlTemplate.Execute(w, report)
+}
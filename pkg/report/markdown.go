@@ -0,0 +1,103 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// markdownCell escapes a value for use in a Markdown table cell
+func markdownCell(value string) string {
+	if value == "" {
+		return "-"
+	}
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(value, "\n", " ")
+}
+
+// writeMarkdownRows writes findings as a Markdown table
+func writeMarkdownRows(b *strings.Builder, rows []FindingRow) {
+	b.WriteString("| Severity | Vulnerability | Package | Version | Fixed in | Path | Asset |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, row := range rows {
+		vulnerability := markdownCell(row.Vulnerability)
+		if row.Link != "" {
+			vulnerability = fmt.Sprintf("[%s](%s)", vulnerability, row.Link)
+		}
+		fmt.Fprintf(b, "| %s | %s | %s | %s | %s | `%s` | %s |\n",
+			markdownCell(row.Severity), vulnerability, markdownCell(row.Package), markdownCell(row.Version),
+			markdownCell(row.FixedVersion), markdownCell(row.Path), markdownCell(row.Asset))
+	}
+}
+
+// WriteMarkdown renders the report as a Markdown summary
+func WriteMarkdown(w io.Writer, report *Report) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Scan report %s\n\n", report.Run.RunID)
+	fmt.Fprintf(&b, "- Started: %s\n", markdownCell(report.Run.StartedAt))
+	fmt.Fprintf(&b, "- Completed: %s\n", markdownCell(report.Run.CompletedAt))
+	fmt.Fprintf(&b, "- wizcli version: %s\n", markdownCell(report.Run.WizcliVersion))
+	fmt.Fprintf(&b, "- Assets: %s\n", markdownCell(strings.Join(report.Assets, ", ")))
+	if sanity := report.Run.SanityCheck; sanity != nil && !sanity.Passed {
+		if sanity.Forced {
+			fmt.Fprintf(&b, "- Sanity check: failed, forced (%s)\n", strings.Join(sanity.Reasons, "; "))
+		} else {
+			fmt.Fprintf(&b, "- Sanity check: **failed, run refused** (%s)\n", strings.Join(sanity.Reasons, "; "))
+		}
+	}
+	fmt.Fprintf(&b, "- Report generated: %s\n", report.GeneratedAt)
+
+	b.WriteString("\n## Findings by severity\n\n")
+	b.WriteString("| Severity | Open | Accepted |\n")
+	b.WriteString("| --- | --- | --- |\n")
+	for _, count := range report.SeverityCounts {
+		fmt.Fprintf(&b, "| %s | %d | %d |\n", markdownCell(count.Severity), count.Open, count.Accepted)
+	}
+	fmt.Fprintf(&b, "| **Total** | **%d** | **%d** |\n", report.TotalOpen, report.TotalAccepted)
+	b.WriteString("\nAccepted findings are suppressed or marked as not affected or fixed by a VEX statement.\n")
+
+	if report.Diff != nil {
+		fmt.Fprintf(&b, "\n## New findings since %s\n\n", report.Diff.From)
+		fmt.Fprintf(&b, "New: %d, resolved: %d, severity changed: %d, version changed: %d\n\n",
+			report.Diff.New, report.Diff.Resolved, report.Diff.SeverityChanged, report.Diff.VersionChanged)
+		if len(report.NewFindings) == 0 {
+			b.WriteString("No new open findings.\n")
+		} else {
+			writeMarkdownRows(&b, report.NewFindings)
+		}
+	}
+
+	b.WriteString("\n## Top packages\n\n")
+	if len(report.TopPackages) == 0 {
+		b.WriteString("No open findings.\n")
+	} else {
+		b.WriteString("| Package | Versions | Findings | Highest severity | Fixable |\n")
+		b.WriteString("| --- | --- | --- | --- | --- |\n")
+		for _, summary := range report.TopPackages {
+			fmt.Fprintf(&b, "| %s | %s | %d | %s | %d |\n", markdownCell(summary.Package),
+				markdownCell(strings.Join(summary.Versions, ", ")), summary.Findings, markdownCell(summary.Highest), summary.Fixable)
+		}
+	}
+
+	b.WriteString("\n## Fixable findings\n\n")
+	if len(report.Fixable) == 0 {
+		b.WriteString("No open finding has a fixed version.\n")
+	} else {
+		writeMarkdownRows(&b, report.Fixable)
+	}
+
+	b.WriteString("\n## Scanned directories\n\n")
+	b.WriteString("| Directory | Status | Open findings |\n")
+	b.WriteString("| --- | --- | --- |\n")
+	for _, target := range report.Targets {
+		status := "OK"
+		if !target.Succeeded {
+			status = "Failed: " + target.Error
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %d |\n", markdownCell(target.Directory), markdownCell(status), target.Findings)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,285 @@
+// Package report renders a scan run as a human-readable HTML page or Markdown summary
+package report
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"scanapp/pkg/config"
+	"scanapp/pkg/vulnerability"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTopPackages is the number of packages listed in the top packages section
+const DefaultTopPackages = 10
+
+// Formats lists the supported report formats
+var Formats = []string{"html", "markdown"}
+
+// SeverityCount is the number of findings of a severity
+type SeverityCount struct {
+	Severity string
+	Open     int // Findings that are neither suppressed nor excluded by VEX
+	Accepted int // Suppressed findings and findings VEX marks as not_affected or fixed
+}
+
+// PackageSummary counts the open findings of a package across assets and paths
+type PackageSummary struct {
+	Package  string
+	Versions []string
+	Findings int
+	Highest  string // Highest severity among the findings
+	Fixable  int
+}
+
+// FindingRow is a single finding as listed in the report
+type FindingRow struct {
+	Asset         string
+	Vulnerability string
+	Severity      string
+	Package       string
+	Version       string
+	FixedVersion  string
+	Path          string
+	Link          string
+}
+
+// TargetStatus is the scan outcome of a scanned directory
+type TargetStatus struct {
+	Directory string
+	Succeeded bool
+	Error     string
+	Findings  int
+}
+
+// Report holds everything shown in a scan report
+type Report struct {
+	Run            vulnerability.RunMetadata
+	GeneratedAt    string
+	Assets         []string
+	TotalOpen      int
+	TotalAccepted  int
+	SeverityCounts []SeverityCount
+	TopPackages    []PackageSummary
+	Fixable        []FindingRow
+	Targets        []TargetStatus
+	// Set when the run is compared against a previous run
+	Diff        *vulnerability.StateDiff
+	NewFindings []FindingRow
+}
+
+// severityRank orders severities from None (0) to Critical (4), unknown severities rank lowest
+func severityRank(severity string) int {
+	for i, wizSeverity := range config.WizSeverities {
+		if severity == wizSeverity {
+			return i
+		}
+	}
+	return -1
+}
+
+// findingRow describes a finding of an asset as a report row
+func findingRow(asset string, finding vulnerability.VulnerabilityFinding) FindingRow {
+	return FindingRow{
+		Asset:         asset,
+		Vulnerability: finding.Name,
+		Severity:      finding.Severity,
+		Package:       finding.DetailedName,
+		Version:       finding.Version,
+		FixedVersion:  finding.FixedVersion,
+		Path:          vulnerability.FindingPath(finding),
+		Link:          finding.ExternalFindingLink,
+	}
+}
+
+// sortRows orders rows by descending severity, then by package, vulnerability and asset
+func sortRows(rows []FindingRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if severityRank(a.Severity) != severityRank(b.Severity) {
+			return severityRank(a.Severity) > severityRank(b.Severity)
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if a.Vulnerability != b.Vulnerability {
+			return a.Vulnerability < b.Vulnerability
+		}
+		return a.Asset < b.Asset
+	})
+}
+
+// isUnder reports whether filePath is dir or lies below it
+func isUnder(filePath, dir string) bool {
+	filePath, dir = path.Clean(filePath), path.Clean(dir)
+	return filePath == dir || dir == "/" || strings.HasPrefix(filePath, dir+"/")
+}
+
+// Build summarizes the state of a run. diff is optional and lists the changes against a previous run,
+// topPackages limits the number of packages listed.
+func Build(metadata vulnerability.RunMetadata, state *vulnerability.VulnerabilityOutput, diff *vulnerability.StateDiff, topPackages int) *Report {
+	report := &Report{
+		Run:         metadata,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Diff:        diff,
+	}
+
+	counts := make(map[string]*SeverityCount)
+	packages := make(map[string]*PackageSummary)
+	var paths []string
+
+	for _, dataSource := range state.DataSources {
+		for _, asset := range dataSource.Assets {
+			providerID := asset.AssetIdentifier.ProviderId
+			report.Assets = append(report.Assets, providerID)
+
+			for _, finding := range asset.VulnerabilityFindings {
+				count, exists := counts[finding.Severity]
+				if !exists {
+					count = &SeverityCount{Severity: finding.Severity}
+					counts[finding.Severity] = count
+				}
+
+				// Accepted risks and VEX statements are counted but not listed
+				if finding.Suppression != nil || finding.VEX.Excluded() {
+					count.Accepted++
+					report.TotalAccepted++
+					continue
+				}
+				count.Open++
+				report.TotalOpen++
+				paths = append(paths, vulnerability.FindingPath(finding))
+
+				summary, exists := packages[finding.DetailedName]
+				if !exists {
+					summary = &PackageSummary{Package: finding.DetailedName}
+					packages[finding.DetailedName] = summary
+				}
+				summary.Findings++
+				if severityRank(finding.Severity) > severityRank(summary.Highest) {
+					summary.Highest = finding.Severity
+				}
+				if !containsString(summary.Versions, finding.Version) {
+					summary.Versions = append(summary.Versions, finding.Version)
+				}
+
+				if finding.FixedVersion != "" {
+					summary.Fixable++
+					report.Fixable = append(report.Fixable, findingRow(providerID, finding))
+				}
+			}
+		}
+	}
+	sortRows(report.Fixable)
+
+	// Severities from Critical down, followed by any severity Wiz doesn't know
+	for i := len(config.WizSeverities) - 1; i >= 0; i-- {
+		severity := config.WizSeverities[i]
+		if count, exists := counts[severity]; exists {
+			report.SeverityCounts = append(report.SeverityCounts, *count)
+		} else {
+			report.SeverityCounts = append(report.SeverityCounts, SeverityCount{Severity: severity})
+		}
+	}
+	var unknown []string
+	for severity := range counts {
+		if !config.IsWizSeverity(severity) {
+			unknown = append(unknown, severity)
+		}
+	}
+	sort.Strings(unknown)
+	for _, severity := range unknown {
+		report.SeverityCounts = append(report.SeverityCounts, *counts[severity])
+	}
+
+	for _, summary := range packages {
+		sort.Strings(summary.Versions)
+		report.TopPackages = append(report.TopPackages, *summary)
+	}
+	sort.Slice(report.TopPackages, func(i, j int) bool {
+		a, b := report.TopPackages[i], report.TopPackages[j]
+		if a.Findings != b.Findings {
+			return a.Findings > b.Findings
+		}
+		if severityRank(a.Highest) != severityRank(b.Highest) {
+			return severityRank(a.Highest) > severityRank(b.Highest)
+		}
+		return a.Package < b.Package
+	})
+	if topPackages > 0 && len(report.TopPackages) > topPackages {
+		report.TopPackages = report.TopPackages[:topPackages]
+	}
+
+	// Scan status per directory, open findings are attributed to the deepest target containing them
+	failed := make(map[string]string)
+	for _, target := range metadata.FailedTargets {
+		failed[target.Directory] = target.Error
+	}
+	findingCounts := make(map[string]int)
+	for _, findingPath := range paths {
+		best := ""
+		for _, target := range metadata.Targets {
+			if isUnder(findingPath, target) && len(target) > len(best) {
+				best = target
+			}
+		}
+		findingCounts[best]++
+	}
+	for _, target := range metadata.Targets {
+		errorText, hasFailed := failed[target]
+		report.Targets = append(report.Targets, TargetStatus{
+			Directory: target,
+			Succeeded: !hasFailed,
+			Error:     errorText,
+			Findings:  findingCounts[target],
+		})
+	}
+
+	if diff != nil {
+		for _, group := range diff.Groups {
+			for _, change := range group.New {
+				if change.After.Suppression != nil || change.After.VEX.Excluded() {
+					continue
+				}
+				report.NewFindings = append(report.NewFindings, findingRow(group.Asset, *change.After))
+			}
+		}
+		sortRows(report.NewFindings)
+	}
+
+	return report
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Write renders the report in the given format: "html" or "markdown"
+func Write(w io.Writer, format string, report *Report) error {
+	switch format {
+	case "html":
+		return WriteHTML(w, report)
+	case "markdown", "md":
+		return WriteMarkdown(w, report)
+	default:
+		return fmt.Errorf("unsupported report format '%s', supported formats are %v", format, Formats)
+	}
+}
+
+// FormatForFile picks the report format from a file name, HTML for .html and .htm files and Markdown otherwise
+func FormatForFile(fileName string) string {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".html", ".htm":
+		return "html"
+	default:
+		return "markdown"
+	}
+}
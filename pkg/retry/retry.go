@@ -0,0 +1,177 @@
+// Package retry sends HTTP requests again after transient failures
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Policy describes how often and how long to wait before sending a request again
+type Policy struct {
+	MaxAttempts   int           // Total number of attempts, including the first one
+	InitialDelay  time.Duration // Delay before the second attempt
+	MaxDelay      time.Duration // Upper bound of the backoff delay
+	Multiplier    float64       // Growth of the delay between attempts
+	Jitter        float64       // Fraction of the delay that is randomized, from 0 to 1
+	MaxRetryAfter time.Duration // Upper bound of a delay requested with Retry-After
+	// Called before waiting for the next attempt, may be nil
+	OnRetry func(attempt int, delay time.Duration, reason string)
+}
+
+// Default returns the policy used for the Wiz API and the uploads
+func Default() Policy {
+	return Policy{
+		MaxAttempts:   5,
+		InitialDelay:  time.Second,
+		MaxDelay:      30 * time.Second,
+		Multiplier:    2,
+		Jitter:        0.5,
+		MaxRetryAfter: 2 * time.Minute,
+	}
+}
+
+// RetryableStatus reports whether a response status is worth another attempt. 429 and 503 mean the
+// request was not processed and are always retried, 502 and 504 only for idempotent requests since
+// the request may have been processed behind the gateway.
+func RetryableStatus(statusCode int, idempotent bool) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	default:
+		return false
+	}
+}
+
+// notSent reports whether a network error happened before the request reached the server,
+// in which case even a non-idempotent request can be sent again
+func notSent(err error) bool {
+	var opError *net.OpError
+	if errors.As(err, &opError) && opError.Op == "dial" {
+		return true
+	}
+	var dnsError *net.DNSError
+	return errors.As(err, &dnsError)
+}
+
+// retryable reports whether a network error is worth another attempt
+func retryable(err error, idempotent bool) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return idempotent || notSent(err)
+}
+
+// Backoff returns the delay before the given attempt, counting the first retry as attempt 2
+func (p Policy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(attempt-2))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	// Spread the retries of concurrent clients
+	if p.Jitter > 0 {
+		delay -= delay * p.Jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// RetryAfter parses a Retry-After header given in seconds or as an HTTP date. It returns false
+// when the header is missing or invalid.
+func RetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// sleep waits for the delay or until the context is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Do sends the request built by newRequest and sends it again after transient failures.
+// newRequest is called for every attempt, so each attempt gets a fresh body. idempotent tells
+// whether the request can safely be processed twice. When the attempts are exhausted on a
+// retryable status the last response is returned, the caller closes its body.
+func (p Policy) Do(ctx context.Context, client *http.Client, idempotent bool, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		request, err := newRequest(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create request: %w", err)
+		}
+
+		response, err := client.Do(request)
+
+		// Work out whether and how long to wait before the next attempt
+		var delay time.Duration
+		var reason string
+		switch {
+		case err != nil:
+			if attempt >= maxAttempts || !retryable(err, idempotent) {
+				return nil, err
+			}
+			delay, reason = p.Backoff(attempt+1), err.Error()
+		case RetryableStatus(response.StatusCode, idempotent):
+			if attempt >= maxAttempts {
+				return response, nil
+			}
+			delay, reason = p.Backoff(attempt+1), "status "+response.Status
+			if retryAfter, ok := RetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+				delay = retryAfter
+				if p.MaxRetryAfter > 0 && delay > p.MaxRetryAfter {
+					delay = p.MaxRetryAfter
+				}
+			}
+
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+			response.Body.Close()
+		default:
+			return response, nil
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(attempt+1, delay, reason)
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
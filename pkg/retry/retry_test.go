@@ -0,0 +1,231 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers the requests with the given statuses in turn, then with 200, and records the bodies
+type flakyServer struct {
+	mu       sync.Mutex
+	statuses []int
+	headers  []http.Header
+	bodies   []string
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	attempt := len(f.bodies)
+	f.bodies = append(f.bodies, string(body))
+	f.mu.Unlock()
+
+	if attempt < len(f.statuses) {
+		if attempt < len(f.headers) {
+			for name, values := range f.headers[attempt] {
+				w.Header()[name] = values
+			}
+		}
+		w.WriteHeader(f.statuses[attempt])
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *flakyServer) attempts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.bodies)
+}
+
+// testPolicy returns a policy with short delays
+func testPolicy() Policy {
+	return Policy{MaxAttempts: 5, InitialDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 2}
+}
+
+// post returns a request builder posting body to url
+func post(url, body string) func(ctx context.Context) (*http.Request, error) {
+	return func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(body))
+	}
+}
+
+func TestDoRetriesThrottledAndUnavailable(t *testing.T) {
+	flaky := &flakyServer{statuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	response, err := testPolicy().Do(context.Background(), server.Client(), false, post(server.URL, "payload"))
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", response.StatusCode)
+	}
+	if got := flaky.attempts(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestDoResendsBodyOnEveryAttempt(t *testing.T) {
+	flaky := &flakyServer{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout}}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	response, err := testPolicy().Do(context.Background(), server.Client(), true, post(server.URL, `{"query":"x"}`))
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	response.Body.Close()
+
+	if len(flaky.bodies) != 4 {
+		t.Fatalf("attempts = %d, want 4", len(flaky.bodies))
+	}
+	for i, body := range flaky.bodies {
+		if body != `{"query":"x"}` {
+			t.Errorf("attempt %d sent body %q", i+1, body)
+		}
+	}
+}
+
+func TestDoHonorsRetryAfter(t *testing.T) {
+	flaky := &flakyServer{
+		statuses: []int{http.StatusTooManyRequests},
+		headers:  []http.Header{{"Retry-After": []string{"1"}}},
+	}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	var delays []time.Duration
+	policy := testPolicy()
+	policy.MaxRetryAfter = time.Minute
+	policy.OnRetry = func(attempt int, delay time.Duration, reason string) {
+		delays = append(delays, delay)
+	}
+
+	started := time.Now()
+	response, err := policy.Do(context.Background(), server.Client(), false, post(server.URL, ""))
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	response.Body.Close()
+
+	if len(delays) != 1 || delays[0] != time.Second {
+		t.Errorf("delays = %v, want [1s]", delays)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("retried after %s, before Retry-After", elapsed)
+	}
+}
+
+func TestDoCapsRetryAfter(t *testing.T) {
+	flaky := &flakyServer{
+		statuses: []int{http.StatusServiceUnavailable},
+		headers:  []http.Header{{"Retry-After": []string{"3600"}}},
+	}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	var delays []time.Duration
+	policy := testPolicy()
+	policy.MaxRetryAfter = 20 * time.Millisecond
+	policy.OnRetry = func(attempt int, delay time.Duration, reason string) {
+		delays = append(delays, delay)
+	}
+
+	response, err := policy.Do(context.Background(), server.Client(), false, post(server.URL, ""))
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	response.Body.Close()
+
+	if len(delays) != 1 || delays[0] != policy.MaxRetryAfter {
+		t.Errorf("delays = %v, want [%s]", delays, policy.MaxRetryAfter)
+	}
+}
+
+func TestDoRetriesNetworkErrors(t *testing.T) {
+	// The first connection is dropped without a response
+	var mu sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		attempt := attempts
+		mu.Unlock()
+
+		if attempt == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	response, err := testPolicy().Do(context.Background(), server.Client(), true, post(server.URL, "payload"))
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	response.Body.Close()
+
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestDoDoesNotRetryBadGatewayWhenNotIdempotent(t *testing.T) {
+	flaky := &flakyServer{statuses: []int{http.StatusBadGateway}}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	response, err := testPolicy().Do(context.Background(), server.Client(), false, post(server.URL, "mutation"))
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", response.StatusCode)
+	}
+	if got := flaky.attempts(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestDoStopsBackoffWhenContextIsCanceled(t *testing.T) {
+	flaky := &flakyServer{statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	policy := testPolicy()
+	policy.InitialDelay = time.Hour
+	policy.MaxDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := policy.Do(ctx, server.Client(), true, post(server.URL, ""))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Do waited %s after the context was done", elapsed)
+	}
+	if got := flaky.attempts(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
@@ -0,0 +1,101 @@
+// in assets.go
+package vulnerability
+
+import (
+	"path/filepath"
+	"scanapp/pkg/config"
+	"strings"
+)
+
+// assetTarget ties an asset to the paths whose findings belong to it
+type assetTarget struct {
+	identifier AssetIdentifier
+	paths      []string
+}
+
+// AssetKey returns the key identifying an asset across runs
+func AssetKey(identifier AssetIdentifier) string {
+	return identifier.CloudPlatform + "/" + identifier.ProviderId
+}
+
+// defaultAssetIdentifier returns the identifier of the scanning host
+func defaultAssetIdentifier(cfg *config.Config) AssetIdentifier {
+	return AssetIdentifier{
+		CloudPlatform: cfg.ScanCloudType,  // Use the cloud platform from cfg
+		ProviderId:    cfg.ScanProviderID, // Use the provider ID from cfg
+	}
+}
+
+// assetTargets returns the additional assets configured for the run
+func assetTargets(cfg *config.Config) []assetTarget {
+	var targets []assetTarget
+	for _, asset := range cfg.Assets {
+		targets = append(targets, assetTarget{
+			identifier: AssetIdentifier{CloudPlatform: asset.CloudPlatform, ProviderId: asset.ProviderID},
+			paths:      asset.Paths,
+		})
+	}
+	return targets
+}
+
+// libraryPath returns the absolute path of a library found while scanning directory
+func libraryPath(directory, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(directory, path)
+}
+
+// isUnder reports whether path equals root or lies below it
+func isUnder(path, root string) bool {
+	root = filepath.Clean(root)
+	path = filepath.Clean(path)
+	return path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// resolveAsset returns the identifier of the asset a finding at path belongs to.
+// The configured asset with the longest matching path wins, otherwise it belongs to the scanning host.
+func resolveAsset(targets []assetTarget, defaultIdentifier AssetIdentifier, scanDirectory, path string) AssetIdentifier {
+	identifier := defaultIdentifier
+	longestMatch := -1
+
+	for _, candidate := range []string{libraryPath(scanDirectory, path), scanDirectory} {
+		for _, target := range targets {
+			for _, root := range target.paths {
+				if isUnder(candidate, root) && len(root) > longestMatch {
+					identifier = target.identifier
+					longestMatch = len(root)
+				}
+			}
+		}
+		if longestMatch >= 0 {
+			break
+		}
+	}
+
+	return identifier
+}
+
+// adoptLegacyAsset assigns the host identifier to the single unidentified asset that histories written
+// before multi-asset support contain, so its findings keep their IDs.
+func adoptLegacyAsset(historicalState *VulnerabilityOutput, identifier AssetIdentifier) {
+	if historicalState == nil {
+		return
+	}
+
+	for i := range historicalState.DataSources {
+		assets := historicalState.DataSources[i].Assets
+		if len(assets) == 1 && assets[0].AssetIdentifier == (AssetIdentifier{}) {
+			assets[0].AssetIdentifier = identifier
+		}
+	}
+}
+
+// identifiersOf returns the identifiers of the given targets
+func identifiersOf(targets []assetTarget) []AssetIdentifier {
+	var identifiers []AssetIdentifier
+	for _, target := range targets {
+		identifiers = append(identifiers, target.identifier)
+	}
+	return identifiers
+}
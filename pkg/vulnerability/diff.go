@@ -0,0 +1,251 @@
+// in diff.go
+package vulnerability
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// FindingChange describes a single finding that differs between two states.
+// Before is empty for new findings and After is empty for resolved findings.
+type FindingChange struct {
+	Vulnerability string                `json:"vulnerability"`
+	Before        *VulnerabilityFinding `json:"before,omitempty"`
+	After         *VulnerabilityFinding `json:"after,omitempty"`
+}
+
+// DiffGroup holds the changes of a single package found at a single path
+type DiffGroup struct {
+	Asset           string          `json:"asset"`
+	Package         string          `json:"package"`
+	Path            string          `json:"path"`
+	New             []FindingChange `json:"new"`
+	Resolved        []FindingChange `json:"resolved"`
+	SeverityChanged []FindingChange `json:"severityChanged"`
+	VersionChanged  []FindingChange `json:"versionChanged"`
+}
+
+// StateDiff is the result of comparing two states
+type StateDiff struct {
+	From            string      `json:"from"`
+	To              string      `json:"to"`
+	New             int         `json:"new"`
+	Resolved        int         `json:"resolved"`
+	SeverityChanged int         `json:"severityChanged"`
+	VersionChanged  int         `json:"versionChanged"`
+	Groups          []DiffGroup `json:"groups"`
+}
+
+// findingKey identifies a finding independently of its version and severity
+type findingKey struct {
+	asset         string
+	pkg           string
+	path          string
+	vulnerability string
+}
+
+// FindingPath returns the path a finding was detected at. States written before the path
+// was recorded only carry it in the description, so it is recovered from there.
+func FindingPath(finding VulnerabilityFinding) string {
+	if finding.Path != "" {
+		return finding.Path
+	}
+
+	const prefix = " was detected in "
+	const suffix = ".  It is vulnerable to "
+	start := strings.Index(finding.Description, prefix)
+	if start == -1 {
+		return ""
+	}
+	rest := finding.Description[start+len(prefix):]
+	end := strings.Index(rest, suffix)
+	if end == -1 {
+		return ""
+	}
+	return rest[:end]
+}
+
+// indexFindings maps every finding of a state by its key, keeping the first occurrence
+func indexFindings(state *VulnerabilityOutput) map[findingKey]VulnerabilityFinding {
+	findings := make(map[findingKey]VulnerabilityFinding)
+	if state == nil {
+		return findings
+	}
+
+	for _, dataSource := range state.DataSources {
+		for _, asset := range dataSource.Assets {
+			for _, vuln := range asset.VulnerabilityFindings {
+				key := findingKey{
+					asset:         asset.AssetIdentifier.ProviderId,
+					pkg:           vuln.DetailedName,
+					path:          FindingPath(vuln),
+					vulnerability: vuln.Name,
+				}
+				if _, exists := findings[key]; !exists {
+					findings[key] = vuln
+				}
+			}
+		}
+	}
+
+	return findings
+}
+
+// DiffStates compares two states and lists new, resolved, severity-changed and version-changed findings
+func DiffStates(from, to *VulnerabilityOutput, fromLabel, toLabel string) *StateDiff {
+	fromFindings := indexFindings(from)
+	toFindings := indexFindings(to)
+
+	groups := make(map[[3]string]*DiffGroup)
+	groupFor := func(key findingKey) *DiffGroup {
+		groupKey := [3]string{key.asset, key.pkg, key.path}
+		group, exists := groups[groupKey]
+		if !exists {
+			group = &DiffGroup{
+				Asset:           key.asset,
+				Package:         key.pkg,
+				Path:            key.path,
+				New:             []FindingChange{},
+				Resolved:        []FindingChange{},
+				SeverityChanged: []FindingChange{},
+				VersionChanged:  []FindingChange{},
+			}
+			groups[groupKey] = group
+		}
+		return group
+	}
+
+	diff := &StateDiff{From: fromLabel, To: toLabel, Groups: []DiffGroup{}}
+
+	for key, after := range toFindings {
+		after := after
+		before, exists := fromFindings[key]
+		if !exists {
+			group := groupFor(key)
+			group.New = append(group.New, FindingChange{Vulnerability: key.vulnerability, After: &after})
+			diff.New++
+			continue
+		}
+
+		change := FindingChange{Vulnerability: key.vulnerability, Before: &before, After: &after}
+		if before.Severity != after.Severity {
+			group := groupFor(key)
+			group.SeverityChanged = append(group.SeverityChanged, change)
+			diff.SeverityChanged++
+		}
+		if before.Version != after.Version {
+			group := groupFor(key)
+			group.VersionChanged = append(group.VersionChanged, change)
+			diff.VersionChanged++
+		}
+	}
+
+	for key, before := range fromFindings {
+		before := before
+		if _, exists := toFindings[key]; !exists {
+			group := groupFor(key)
+			group.Resolved = append(group.Resolved, FindingChange{Vulnerability: key.vulnerability, Before: &before})
+			diff.Resolved++
+		}
+	}
+
+	// Sort everything so the output is stable between invocations
+	for _, group := range groups {
+		for _, changes := range [][]FindingChange{group.New, group.Resolved, group.SeverityChanged, group.VersionChanged} {
+			sort.Slice(changes, func(i, j int) bool {
+				return changes[i].Vulnerability < changes[j].Vulnerability
+			})
+		}
+		diff.Groups = append(diff.Groups, *group)
+	}
+	sort.Slice(diff.Groups, func(i, j int) bool {
+		a, b := diff.Groups[i], diff.Groups[j]
+		if a.Asset != b.Asset {
+			return a.Asset < b.Asset
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Path < b.Path
+	})
+
+	return diff
+}
+
+// WriteDiff renders the diff in the given format: "text", "json" or "markdown"
+func WriteDiff(w io.Writer, diff *StateDiff, format string) error {
+	switch format {
+	case "text", "":
+		return writeDiffText(w, diff)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	case "markdown", "md":
+		return writeDiffMarkdown(w, diff)
+	default:
+		return fmt.Errorf("unsupported diff format '%s'", format)
+	}
+}
+
+// writeDiffText renders the diff as plain text
+func writeDiffText(w io.Writer, diff *StateDiff) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Changes from %s to %s\n", diff.From, diff.To)
+	fmt.Fprintf(&b, "New: %d  Resolved: %d  Severity changed: %d  Version changed: %d\n",
+		diff.New, diff.Resolved, diff.SeverityChanged, diff.VersionChanged)
+
+	for _, group := range diff.Groups {
+		fmt.Fprintf(&b, "\n%s  %s  %s\n", group.Asset, group.Package, group.Path)
+		for _, change := range group.New {
+			fmt.Fprintf(&b, "  + %s (%s) in version %s\n", change.Vulnerability, change.After.Severity, change.After.Version)
+		}
+		for _, change := range group.Resolved {
+			fmt.Fprintf(&b, "  - %s (%s) in version %s\n", change.Vulnerability, change.Before.Severity, change.Before.Version)
+		}
+		for _, change := range group.SeverityChanged {
+			fmt.Fprintf(&b, "  ~ %s severity %s -> %s\n", change.Vulnerability, change.Before.Severity, change.After.Severity)
+		}
+		for _, change := range group.VersionChanged {
+			fmt.Fprintf(&b, "  ~ %s version %s -> %s\n", change.Vulnerability, change.Before.Version, change.After.Version)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeDiffMarkdown renders the diff as a Markdown document
+func writeDiffMarkdown(w io.Writer, diff *StateDiff) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Changes from %s to %s\n\n", diff.From, diff.To)
+	b.WriteString("| New | Resolved | Severity changed | Version changed |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d |\n", diff.New, diff.Resolved, diff.SeverityChanged, diff.VersionChanged)
+
+	for _, group := range diff.Groups {
+		fmt.Fprintf(&b, "\n## %s\n\nAsset `%s`, path `%s`\n\n", group.Package, group.Asset, group.Path)
+		b.WriteString("| Change | Vulnerability | Severity | Version |\n")
+		b.WriteString("| --- | --- | --- | --- |\n")
+		for _, change := range group.New {
+			fmt.Fprintf(&b, "| New | %s | %s | %s |\n", change.Vulnerability, change.After.Severity, change.After.Version)
+		}
+		for _, change := range group.Resolved {
+			fmt.Fprintf(&b, "| Resolved | %s | %s | %s |\n", change.Vulnerability, change.Before.Severity, change.Before.Version)
+		}
+		for _, change := range group.SeverityChanged {
+			fmt.Fprintf(&b, "| Severity changed | %s | %s → %s | %s |\n", change.Vulnerability, change.Before.Severity, change.After.Severity, change.After.Version)
+		}
+		for _, change := range group.VersionChanged {
+			fmt.Fprintf(&b, "| Version changed | %s | %s | %s → %s |\n", change.Vulnerability, change.After.Severity, change.Before.Version, change.After.Version)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,222 @@
+// in enrichment.go
+package vulnerability
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EPSSScore holds the EPSS probability and percentile of a CVE
+type EPSSScore struct {
+	Probability float64
+	Percentile  float64
+}
+
+// KEVEntry holds the CISA Known Exploited Vulnerabilities catalog entry of a CVE
+type KEVEntry struct {
+	CveID                      string `json:"cveID"`
+	DateAdded                  string `json:"dateAdded"`
+	DueDate                    string `json:"dueDate"`
+	KnownRansomwareCampaignUse string `json:"knownRansomwareCampaignUse"`
+}
+
+// CVSSData holds the CVSS v3 base score and vector of a CVE
+type CVSSData struct {
+	Score  float64
+	Vector string
+}
+
+// nvdCVSSMetric is a CVSS v3 metric of a CVE in the NVD CVE JSON 2.0 format
+type nvdCVSSMetric struct {
+	Type     string `json:"type"`
+	CVSSData struct {
+		VectorString string  `json:"vectorString"`
+		BaseScore    float64 `json:"baseScore"`
+	} `json:"cvssData"`
+}
+
+// LoadEPSS reads an EPSS scores CSV file as published by FIRST, optionally gzip compressed.
+// The file starts with a "#model_version" comment line followed by a "cve,epss,percentile" header.
+func LoadEPSS(path string) (map[string]EPSSScore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open EPSS file: %v", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress EPSS file: %v", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	csvReader := csv.NewReader(reader)
+	csvReader.Comment = '#'
+	csvReader.FieldsPerRecord = -1
+
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse EPSS file: %v", err)
+	}
+
+	scores := make(map[string]EPSSScore)
+	for _, record := range records {
+		if len(record) < 3 || strings.EqualFold(record[0], "cve") {
+			continue // Skip the header and malformed rows
+		}
+
+		probability, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EPSS score for %s: %v", record[0], err)
+		}
+		percentile, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EPSS percentile for %s: %v", record[0], err)
+		}
+
+		scores[strings.ToUpper(record[0])] = EPSSScore{Probability: probability, Percentile: percentile}
+	}
+
+	return scores, nil
+}
+
+// LoadKEV reads the CISA Known Exploited Vulnerabilities catalog in its JSON format
+func LoadKEV(path string) (map[string]KEVEntry, error) {
+	var catalog struct {
+		Vulnerabilities []KEVEntry `json:"vulnerabilities"`
+	}
+	if err := readJSONFile(path, &catalog); err != nil {
+		return nil, fmt.Errorf("cannot read KEV file: %v", err)
+	}
+
+	entries := make(map[string]KEVEntry)
+	for _, entry := range catalog.Vulnerabilities {
+		entries[strings.ToUpper(entry.CveID)] = entry
+	}
+
+	return entries, nil
+}
+
+// LoadNVD reads the CVSS v3 data of an NVD CVE JSON 2.0 file, a yearly feed (`nvdcve-2.0-YYYY.json`)
+// or a saved API response, optionally gzip compressed. The primary CVSS v3.1 metric is preferred
+// over secondary and CVSS v3.0 ones.
+func LoadNVD(path string) (map[string]CVSSData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open NVD file: %v", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress NVD file: %v", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	var feed struct {
+		Vulnerabilities []struct {
+			CVE struct {
+				ID      string `json:"id"`
+				Metrics struct {
+					CVSSMetricV31 []nvdCVSSMetric `json:"cvssMetricV31"`
+					CVSSMetricV30 []nvdCVSSMetric `json:"cvssMetricV30"`
+				} `json:"metrics"`
+			} `json:"cve"`
+		} `json:"vulnerabilities"`
+	}
+	if err := json.NewDecoder(reader).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("cannot parse NVD file: %v", err)
+	}
+
+	data := make(map[string]CVSSData)
+	for _, vuln := range feed.Vulnerabilities {
+		for _, metrics := range [][]nvdCVSSMetric{vuln.CVE.Metrics.CVSSMetricV31, vuln.CVE.Metrics.CVSSMetricV30} {
+			metric, found := primaryMetric(metrics)
+			if found {
+				data[strings.ToUpper(vuln.CVE.ID)] = CVSSData{Score: metric.CVSSData.BaseScore, Vector: metric.CVSSData.VectorString}
+				break
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// primaryMetric returns the primary metric with a vector, or the first one with a vector
+func primaryMetric(metrics []nvdCVSSMetric) (nvdCVSSMetric, bool) {
+	var fallback *nvdCVSSMetric
+	for i, metric := range metrics {
+		if metric.CVSSData.VectorString == "" {
+			continue
+		}
+		if metric.Type == "Primary" {
+			return metric, true
+		}
+		if fallback == nil {
+			fallback = &metrics[i]
+		}
+	}
+	if fallback == nil {
+		return nvdCVSSMetric{}, false
+	}
+	return *fallback, true
+}
+
+// EnrichFindings adds the CVSS vector, EPSS scores and the known-exploited flag to every finding of the
+// state. The NVD score is only used when wizcli reported none. Any map may be nil. It returns the number
+// of findings that were enriched.
+func EnrichFindings(state *VulnerabilityOutput, nvd map[string]CVSSData, epss map[string]EPSSScore, kev map[string]KEVEntry) int {
+	enriched := 0
+
+	for i := range state.DataSources {
+		for j := range state.DataSources[i].Assets {
+			findings := state.DataSources[i].Assets[j].VulnerabilityFindings
+			for k := range findings {
+				cve := strings.ToUpper(findings[k].Name)
+				matched := false
+
+				if cvss, exists := nvd[cve]; exists {
+					findings[k].CVSSVector = cvss.Vector
+					if findings[k].CVSSScore == 0 {
+						findings[k].CVSSScore = cvss.Score
+					}
+					matched = true
+				}
+				if score, exists := epss[cve]; exists {
+					findings[k].EPSSProbability = score.Probability
+					findings[k].EPSSPercentile = score.Percentile
+					matched = true
+				}
+				if kev != nil {
+					// Not being in the catalog is recorded too, so it differs from not having checked
+					entry, exists := kev[cve]
+					findings[k].KnownExploited = &exists
+					if exists {
+						findings[k].KEVDateAdded = entry.DateAdded
+						matched = true
+					}
+				}
+
+				if matched {
+					enriched++
+				}
+			}
+		}
+	}
+
+	return enriched
+}
@@ -0,0 +1,135 @@
+// in inventory.go
+package vulnerability
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InventoryItem is a package wizcli found, whether or not it has vulnerabilities
+type InventoryItem struct {
+	Asset           AssetIdentifier `json:"asset"`
+	Section         string          `json:"section"`
+	Name            string          `json:"name"`
+	Version         string          `json:"version"`
+	Path            string          `json:"path"`
+	DetectionMethod string          `json:"detectionMethod"`
+	Vulnerabilities []string        `json:"vulnerabilities,omitempty"`
+	// Distribution of an OS package, nil when its file system has no os-release file
+	OSRelease *OSRelease `json:"osRelease,omitempty"`
+}
+
+// OSRelease identifies a Linux distribution by the ID and VERSION_ID fields of its os-release file
+type OSRelease struct {
+	ID        string `json:"id"`
+	VersionID string `json:"versionId,omitempty"`
+}
+
+// osPackageDatabases are the directories OS package managers keep their database in, by package URL type
+var osPackageDatabases = []struct {
+	dir      string
+	purlType string
+}{
+	{"/var/lib/dpkg/", "deb"},
+	{"/var/lib/rpm/", "rpm"},
+	{"/usr/lib/sysimage/rpm/", "rpm"},
+	{"/lib/apk/db/", "apk"},
+}
+
+// OSPackageDatabase returns the package URL type of the OS package database a path lies in, and the root of
+// the file system holding it. Whole path components are matched. The type is empty for any other path.
+func OSPackageDatabase(path string) (purlType, root string) {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	path += "/"
+
+	for _, database := range osPackageDatabases {
+		if i := strings.Index(path, database.dir); i != -1 {
+			root = path[:i]
+			if root == "" {
+				root = "/"
+			}
+			return database.purlType, root
+		}
+	}
+	return "", ""
+}
+
+// osReleaseCache holds the os-release of every file system root read so far, nil when it has none
+type osReleaseCache map[string]*OSRelease
+
+// lookup returns the distribution of an OS package found at path while scanning directory
+func (c osReleaseCache) lookup(directory, path string) *OSRelease {
+	purlType, root := OSPackageDatabase(libraryPath(directory, path))
+	if purlType == "" {
+		return nil
+	}
+
+	release, exists := c[root]
+	if !exists {
+		release = readOSRelease(root)
+		c[root] = release
+	}
+	return release
+}
+
+// readOSRelease reads the os-release file of a file system root, nil when it is missing or has no ID
+func readOSRelease(root string) *OSRelease {
+	for _, name := range []string{"etc/os-release", "usr/lib/os-release"} {
+		file, err := os.Open(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		release := &OSRelease{}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if !found {
+				continue
+			}
+			value = strings.Trim(value, `"'`)
+			switch key {
+			case "ID":
+				release.ID = strings.ToLower(value)
+			case "VERSION_ID":
+				release.VersionID = value
+			}
+		}
+		if release.ID == "" {
+			return nil
+		}
+		return release
+	}
+	return nil
+}
+
+// addInventoryItem appends a package to the inventory unless the same package was already seen
+// at the same path of the same asset
+func addInventoryItem(inventory []InventoryItem, seen map[string]bool, identifier AssetIdentifier, section string, library Library, release *OSRelease) []InventoryItem {
+	key := AssetKey(identifier) + "\n" + section + "\n" + library.Name + "\n" + library.Version + "\n" + library.Path
+	if seen[key] {
+		return inventory
+	}
+	seen[key] = true
+
+	item := InventoryItem{
+		Asset:           identifier,
+		Section:         section,
+		Name:            library.Name,
+		Version:         library.Version,
+		Path:            library.Path,
+		DetectionMethod: library.DetectionMethod,
+		OSRelease:       release,
+	}
+	for _, vuln := range library.Vulnerabilities {
+		item.Vulnerabilities = append(item.Vulnerabilities, vuln.Name)
+	}
+
+	return append(inventory, item)
+}
@@ -0,0 +1,35 @@
+package vulnerability
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOSReleaseLookup(t *testing.T) {
+	// A file system mounted on the scanning host, with its own os-release
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	osRelease := "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nNAME=\"Debian GNU/Linux\"\nVERSION_ID=\"12\"\nID=debian\n"
+	if err := os.WriteFile(filepath.Join(root, "etc", "os-release"), []byte(osRelease), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	releases := make(osReleaseCache)
+
+	release := releases.lookup(root, "var/lib/dpkg/status")
+	if release == nil || *release != (OSRelease{ID: "debian", VersionID: "12"}) {
+		t.Errorf("release = %+v, want debian 12", release)
+	}
+	if release := releases.lookup("/", filepath.Join(root, "var/lib/dpkg/status")); release == nil || release.ID != "debian" {
+		t.Errorf("release of an absolute path = %+v, want debian", release)
+	}
+	if release := releases.lookup(root, "usr/share/doc/apk/readme"); release != nil {
+		t.Errorf("release of a path outside a package database = %+v, want nil", release)
+	}
+	if release := releases.lookup(t.TempDir(), "lib/apk/db/installed"); release != nil {
+		t.Errorf("release of a root without os-release = %+v, want nil", release)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"scanapp/pkg/config"
+	"scanapp/pkg/wizcli"
 	"strings"
 	"time"
 
@@ -96,54 +97,81 @@ type VulnerabilityFinding struct {
 	Severity                string `json:"severity"`
 	ExternalFindingLink     string `json:"externalFindingLink"`
 	Version                 string `json:"version"`
+	Path                    string `json:"path,omitempty"`
 	Source                  string `json:"source"`
 	Remediation             string `json:"remediation"`
 	FixedVersion            string `json:"fixedVersion"`
 	ValidatedAtRuntime      bool   `json:"validatedAtRuntime"`
 	Description             string `json:"description"`
+	FirstSeen               string `json:"firstSeen,omitempty"`
+	// Prioritization data, from wizcli or the local NVD, EPSS and KEV files
+	CVSSScore           float64 `json:"cvssScore,omitempty"`
+	CVSSVector          string  `json:"cvssVector,omitempty"`
+	ExploitabilityScore float64 `json:"exploitabilityScore,omitempty"`
+	EPSSProbability     float64 `json:"epssProbability,omitempty"`
+	EPSSPercentile      float64 `json:"epssPercentile,omitempty"`
+	KnownExploited      *bool   `json:"knownExploited,omitempty"` // nil when no KEV catalog was loaded
+	KEVDateAdded        string  `json:"kevDateAdded,omitempty"`
+	// Set when an accepted-risk rule applies, suppressed findings are kept in state but not uploaded
+	Suppression *SuppressionInfo `json:"suppression,omitempty"`
+	// Set when a VEX statement applies, not_affected and fixed findings are not uploaded
+	VEX *VEXInfo `json:"vex,omitempty"`
 }
 
-func adjustSeverity(severity string) string {
-	// Define the allowed severities
-	allowedSeverities := []string{"None", "Low", "Medium", "High", "Critical"}
-
-	// Check if the severity is in the allowed list
-	for _, allowedSeverity := range allowedSeverities {
-		if severity == allowedSeverity {
-			return severity // Return the valid severity
-		}
-	}
-
-	// If the severity wasn't found in the allowed list, return "None"
-	return "None"
+// ProcessReport collects what was noticed while processing the scan results
+type ProcessReport struct {
+	// Vendor severities without a mapping, with the number of findings that carried them
+	UnmappedSeverities map[string]int
+	// Number of findings marked as suppressed
+	SuppressedCount int
+	// Suppression rules past their expiry date, they were not applied
+	ExpiredSuppressions []Suppression
+	// Number of findings a VEX statement applied to, per VEX status
+	VEXStatusCounts map[string]int
+	// Every package wizcli reported, including the ones without vulnerabilities
+	Inventory []InventoryItem
 }
 
-// ProcessVulnerabilities takes a slice of JSON strings and processes the vulnerabilities.
-func ProcessVulnerabilities(jsonOutputs []string, cfg *config.Config, historicalState *VulnerabilityOutput) (*VulnerabilityOutput, error) {
+// ProcessVulnerabilities takes the wizcli scan results and processes the vulnerabilities.
+// Findings are attributed to the configured assets by path, the rest belong to the scanning host.
+// The suppression rules and VEX statements are loaded by the caller, before the scan, so a broken file fails fast.
+func ProcessVulnerabilities(scanResults []wizcli.ScanResult, cfg *config.Config, historicalState *VulnerabilityOutput, allSuppressions []Suppression, vexStatements []VEXStatement) (*VulnerabilityOutput, *ProcessReport, error) {
 
 	// Initialize nextId to 1
 	nextId := 1
 
-	// Build a map of historical vulnerability findings for quick comparisons
-	historicalVulnerabilitiesMap := make(map[string]string)
+	severities := newSeverityMapper(cfg)
+	report := &ProcessReport{VEXStatusCounts: make(map[string]int), Inventory: []InventoryItem{}}
+	inventorySeen := make(map[string]bool)
+	osReleases := make(osReleaseCache)
 
-	if historicalState != nil && len(historicalState.DataSources) > 0 {
-		for _, asset := range historicalState.DataSources[0].Assets {
-			for _, vuln := range asset.VulnerabilityFindings {
-				historicalVulnerabilitiesMap[vuln.Description] = vuln.ID
-			}
-		}
-	}
+	// Expired accepted-risk rules are reported instead of applied
+	suppressions, expiredSuppressions := activeSuppressions(allSuppressions, time.Now().UTC())
+	report.ExpiredSuppressions = expiredSuppressions
 
-	// Count the existing VulnerabilityFindings in historicalState
-	if historicalState != nil && len(historicalState.DataSources) > 0 {
-		for _, asset := range historicalState.DataSources[0].Assets {
-			nextId += len(asset.VulnerabilityFindings)
+	defaultIdentifier := defaultAssetIdentifier(cfg)
+	targets := assetTargets(cfg)
+
+	// Histories written before multi-asset support hold a single unidentified asset
+	adoptLegacyAsset(historicalState, defaultIdentifier)
+
+	// Build a map of historical vulnerability findings per asset for quick comparisons
+	historicalVulnerabilitiesMap := make(map[string]VulnerabilityFinding)
+
+	if historicalState != nil {
+		for _, dataSource := range historicalState.DataSources {
+			for _, asset := range dataSource.Assets {
+				for _, vuln := range asset.VulnerabilityFindings {
+					historicalVulnerabilitiesMap[AssetKey(asset.AssetIdentifier)+"\n"+vuln.Description] = vuln
+					// Count the existing VulnerabilityFindings in historicalState
+					nextId++
+				}
+			}
 		}
 	}
 
 	vulnerabilityOutput := &VulnerabilityOutput{
-		IntegrationID: "e7ddcf48-a2f3-fd39-89f4-b27c4efca17c", // or however you're obtaining this
+		IntegrationID: cfg.ResolvedIntegrationID(),
 		DataSources:   []DataSource{},
 	}
 
@@ -152,26 +180,36 @@ func ProcessVulnerabilities(jsonOutputs []string, cfg *config.Config, historical
 
 	// Dummy data for DataSource, replace with your actual logic to obtain these
 	dataSource := DataSource{
-		ID:           cfg.ScanSubscriptionID,
+		ID:           cfg.ResolvedDataSourceID(),
 		AnalysisDate: currentTime, // Set to the current time
 		Assets:       []Asset{},   // Assets will be filled later
 	}
 
-	// Create a single asset for all vulnerabilities
-	asset := Asset{
-		AssetIdentifier: AssetIdentifier{
-			CloudPlatform: cfg.ScanCloudType,  // Use the cloud platform from cfg
-			ProviderId:    cfg.ScanProviderID, // Use the provider ID from cfg
-		},
-		VulnerabilityFindings: []VulnerabilityFinding{},
+	// Create one asset for the host and one per configured asset, in a stable order
+	assets := make(map[string]*Asset)
+	assetOrder := []string{}
+	for _, identifier := range append([]AssetIdentifier{defaultIdentifier}, identifiersOf(targets)...) {
+		key := AssetKey(identifier)
+		if _, exists := assets[key]; exists {
+			continue
+		}
+		assets[key] = &Asset{
+			AssetIdentifier:       identifier,
+			VulnerabilityFindings: []VulnerabilityFinding{},
+		}
+		assetOrder = append(assetOrder, key)
 	}
 
-	// Iterate through each json output
-	for _, jsonString := range jsonOutputs {
+	// Iterate through each successful scan
+	for _, scanResult := range scanResults {
+		if !scanResult.Succeeded() {
+			continue
+		}
+
 		var scanData ScanData
-		err := json.Unmarshal([]byte(jsonString), &scanData)
+		err := json.Unmarshal([]byte(scanResult.JSON), &scanData)
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling json: %v", err)
+			return nil, nil, fmt.Errorf("error unmarshaling json: %v", err)
 		}
 
 		// Accumulate vulnerabilities from different sections
@@ -185,25 +223,39 @@ func ProcessVulnerabilities(jsonOutputs []string, cfg *config.Config, historical
 			{"cpes", scanData.Result.Cpes},
 		} {
 			for _, item := range section.Vulnerabilities {
+				// Find the asset this package belongs to
+				asset := assets[AssetKey(resolveAsset(targets, defaultIdentifier, scanResult.Directory, item.Path))]
+
+				// Keep every package, vulnerable or not, for the SBOM exports
+				var release *OSRelease
+				if section.Label == "osPackages" {
+					release = osReleases.lookup(scanResult.Directory, item.Path)
+				}
+				report.Inventory = addInventoryItem(report.Inventory, inventorySeen, asset.AssetIdentifier, section.Label, item, release)
+
 				for _, vuln := range item.Vulnerabilities {
 					// Build a unique description or identifier if needed
 					description := fmt.Sprintf("The %s %s version %s was detected in %s.  It is vulnerable to %s, which exists in versions <%s.  The vulnerability was found in the %s with vendor severity of %s", item.DetectionMethod, item.Name, item.Version, item.Path, vuln.Name, vuln.FixedVersion, item.DetectionMethod, vuln.Severity)
 
 					// Check if this finding already exists in historicalState
-					id, exists := historicalVulnerabilitiesMap[description]
+					historicalFinding, exists := historicalVulnerabilitiesMap[AssetKey(asset.AssetIdentifier)+"\n"+description]
+					id, firstSeen := historicalFinding.ID, historicalFinding.FirstSeen
 					if !exists {
 						// Assign a new ID and increment nextId
 						id = fmt.Sprintf("%d", nextId)
 						nextId++
 					}
+					if firstSeen == "" {
+						// New finding, or history written before first-seen dates were recorded
+						firstSeen = currentTime
+					}
 
 					// Convert all caps to Title
 					titleCaser := cases.Title(language.English)
 					detectionMethod := titleCaser.String(strings.ToLower(item.DetectionMethod))
-					severity := titleCaser.String(strings.ToLower(vuln.Severity))
 
-					// Validate severity
-					severity = adjustSeverity(severity)
+					// Map the vendor severity to a Wiz severity
+					severity := severities.Map(item.DetectionMethod, section.Label, vuln.Severity, vuln.Score)
 
 					// Build your VulnerabilityFinding from the Vulnerability data
 					vulnerabilityFinding := VulnerabilityFinding{
@@ -214,12 +266,27 @@ func ProcessVulnerabilities(jsonOutputs []string, cfg *config.Config, historical
 						Severity:                severity,
 						ExternalFindingLink:     vuln.Source,
 						Version:                 item.Version,
+						Path:                    item.Path,
 						Source:                  "wizcli",
 						Remediation:             vuln.FixedVersion,
 						FixedVersion:            vuln.FixedVersion,
 						ValidatedAtRuntime:      false,
 						Description:             description,
+						FirstSeen:               firstSeen,
+						CVSSScore:               vuln.Score,
+						ExploitabilityScore:     vuln.ExploitabilityScore,
+					}
+
+					// Mark findings covered by an accepted-risk rule
+					if applySuppressions(suppressions, asset.AssetIdentifier, &vulnerabilityFinding) {
+						report.SuppressedCount++
+					}
+
+					// Record the vendor's VEX status
+					if applyVEX(vexStatements, &vulnerabilityFinding) {
+						report.VEXStatusCounts[vulnerabilityFinding.VEX.Status]++
 					}
+
 					// Append the vulnerabilityFinding to the asset's VulnerabilityFindings slice
 					asset.VulnerabilityFindings = append(asset.VulnerabilityFindings, vulnerabilityFinding)
 				}
@@ -227,11 +294,20 @@ func ProcessVulnerabilities(jsonOutputs []string, cfg *config.Config, historical
 		}
 	}
 
-	// After the loop, add the asset with all vulnerabilities to the dataSource
-	dataSource.Assets = append(dataSource.Assets, asset)
+	// After the loop, add the assets with their vulnerabilities to the dataSource.
+	// The host is left out when it has no identifier and nothing was attributed to it.
+	for _, key := range assetOrder {
+		asset := assets[key]
+		if asset.AssetIdentifier.ProviderId == "" && len(asset.VulnerabilityFindings) == 0 && len(assetOrder) > 1 {
+			continue
+		}
+		dataSource.Assets = append(dataSource.Assets, *asset)
+	}
 
 	// Add the dataSource to the vulnerabilityOutput
 	vulnerabilityOutput.DataSources = append(vulnerabilityOutput.DataSources, dataSource)
 
-	return vulnerabilityOutput, nil
+	report.UnmappedSeverities = severities.unmapped
+
+	return vulnerabilityOutput, report, nil
 }
@@ -0,0 +1,91 @@
+// in sanity.go
+package vulnerability
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SanityCheck records the outcome of comparing a run against the previous accepted run
+type SanityCheck struct {
+	BaselineRunID string   `json:"baselineRunId,omitempty"`
+	Passed        bool     `json:"passed"`
+	Forced        bool     `json:"forced"`
+	Reasons       []string `json:"reasons,omitempty"`
+}
+
+// Accepted reports whether the run may be used to update history and be uploaded
+func (s *SanityCheck) Accepted() bool {
+	return s == nil || s.Passed || s.Forced
+}
+
+// LatestAcceptedRun returns the most recent run snapshot that passed or was forced past its sanity check.
+// It returns nil if there is none.
+func LatestAcceptedRun() (*RunMetadata, error) {
+	runs, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].SanityCheck.Accepted() {
+			return &runs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// CheckSanity compares the current run against a baseline run and reports anything that suggests
+// the scan collapsed, such as every target failing, targets going missing or findings dropping sharply.
+// baseline may be nil when there is no previous run.
+func CheckSanity(currentState *VulnerabilityOutput, targets []string, failedTargets []FailedTarget, baseline *RunMetadata, maxDropPercent int) *SanityCheck {
+	check := &SanityCheck{}
+	findingCount, _ := countFindings(currentState)
+
+	// A scan where wizcli failed on every target says nothing about the host
+	if len(targets) > 0 && len(failedTargets) == len(targets) {
+		check.Reasons = append(check.Reasons, fmt.Sprintf("wizcli failed on all %d scan targets", len(targets)))
+	}
+
+	if baseline != nil {
+		check.BaselineRunID = baseline.RunID
+
+		// Targets that were scanned successfully before must still be scanned successfully
+		scanned := make(map[string]bool)
+		for _, target := range targets {
+			scanned[target] = true
+		}
+		for _, failed := range failedTargets {
+			scanned[failed.Directory] = false
+		}
+		previouslyFailed := make(map[string]bool)
+		for _, failed := range baseline.FailedTargets {
+			previouslyFailed[failed.Directory] = true
+		}
+
+		var missing []string
+		for _, target := range baseline.Targets {
+			if !previouslyFailed[target] && !scanned[target] {
+				missing = append(missing, target)
+			}
+		}
+		if len(missing) > 0 {
+			check.Reasons = append(check.Reasons, fmt.Sprintf("targets scanned in run %s are missing or failed: %s", baseline.RunID, strings.Join(missing, ", ")))
+		}
+
+		// Compare the number of findings with the baseline
+		if baseline.FindingCount > 0 {
+			if findingCount == 0 {
+				check.Reasons = append(check.Reasons, fmt.Sprintf("no findings while run %s had %d", baseline.RunID, baseline.FindingCount))
+			} else if maxDropPercent > 0 {
+				dropPercent := (baseline.FindingCount - findingCount) * 100 / baseline.FindingCount
+				if dropPercent > maxDropPercent {
+					check.Reasons = append(check.Reasons, fmt.Sprintf("findings dropped by %d%% from %d in run %s to %d (limit %d%%)", dropPercent, baseline.FindingCount, baseline.RunID, findingCount, maxDropPercent))
+				}
+			}
+		}
+	}
+
+	check.Passed = len(check.Reasons) == 0
+	return check
+}
@@ -0,0 +1,108 @@
+// in severity.go
+package vulnerability
+
+import (
+	"scanapp/pkg/config"
+	"strings"
+)
+
+// defaultSeverityMapping maps common vendor severity labels to Wiz severities.
+// Keys are lower case, the configured mappings take precedence.
+var defaultSeverityMapping = map[string]string{
+	"none":          "None",
+	"info":          "None",
+	"informational": "None",
+	"negligible":    "Low",
+	"low":           "Low",
+	"minor":         "Low",
+	"medium":        "Medium",
+	"moderate":      "Medium",
+	"high":          "High",
+	"important":     "High",
+	"critical":      "Critical",
+}
+
+// severityMapper turns vendor severities into Wiz severities and remembers the labels it couldn't map
+type severityMapper struct {
+	mapping       map[string]string
+	sourceMapping map[string]map[string]string
+	fromScore     bool
+	unmapped      map[string]int
+}
+
+// newSeverityMapper builds a mapper from the defaults and the configured mappings
+func newSeverityMapper(cfg *config.Config) *severityMapper {
+	mapper := &severityMapper{
+		mapping:       make(map[string]string),
+		sourceMapping: make(map[string]map[string]string),
+		fromScore:     cfg.SeverityFromScore,
+		unmapped:      make(map[string]int),
+	}
+
+	for label, severity := range defaultSeverityMapping {
+		mapper.mapping[label] = severity
+	}
+	for label, severity := range cfg.SeverityMapping {
+		mapper.mapping[strings.ToLower(label)] = severity
+	}
+	for source, mapping := range cfg.SourceSeverityMapping {
+		labels := make(map[string]string)
+		for label, severity := range mapping {
+			labels[strings.ToLower(label)] = severity
+		}
+		mapper.sourceMapping[strings.ToLower(source)] = labels
+	}
+
+	return mapper
+}
+
+// severityFromScore maps a CVSS score to a severity using the CVSS v3 qualitative rating scale
+func severityFromScore(score float64) string {
+	switch {
+	case score >= 9.0:
+		return "Critical"
+	case score >= 7.0:
+		return "High"
+	case score >= 4.0:
+		return "Medium"
+	case score > 0:
+		return "Low"
+	default:
+		return "None"
+	}
+}
+
+// Map returns the Wiz severity for a vendor severity label. Mappings for the detection method or
+// the section are tried first, then the global mapping. Missing or unmapped labels fall back to the
+// CVSS score when enabled and to "None" otherwise.
+func (m *severityMapper) Map(detectionMethod, section, label string, score float64) string {
+	normalized := strings.ToLower(strings.TrimSpace(label))
+	missing := normalized == "" || normalized == "unknown"
+
+	if !missing {
+		for _, source := range []string{detectionMethod, section} {
+			if severity, exists := m.sourceMapping[strings.ToLower(source)][normalized]; exists {
+				return severity
+			}
+		}
+		if severity, exists := m.mapping[normalized]; exists {
+			return severity
+		}
+
+		// A label nothing maps is reported even when the score stands in for it
+		m.unmapped[normalized]++
+	}
+
+	// Derive the severity from the score when the label doesn't tell us
+	if m.fromScore && score > 0 {
+		return severityFromScore(score)
+	}
+
+	if missing {
+		if normalized == "" {
+			normalized = "(empty)"
+		}
+		m.unmapped[normalized]++
+	}
+	return "None"
+}
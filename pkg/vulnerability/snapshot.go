@@ -0,0 +1,354 @@
+// in snapshot.go
+package vulnerability
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotDir is the directory holding one immutable snapshot per scan run
+const SnapshotDir = "state-runs"
+
+// Files written inside each snapshot directory
+const (
+	snapshotMetadataFile   = "metadata.json"
+	snapshotCurrentFile    = "state-current.json"
+	snapshotHistoricalFile = "state-historical.json"
+	snapshotInventoryFile  = "inventory.json"
+)
+
+// currentRunFile is the marker in SnapshotDir naming the run the state files hold
+const currentRunFile = "CURRENT"
+
+// RunMetadata describes a single scan run stored as a snapshot
+type RunMetadata struct {
+	RunID                  string         `json:"runId"`
+	StartedAt              string         `json:"startedAt"`
+	CompletedAt            string         `json:"completedAt"`
+	Host                   string         `json:"host,omitempty"`
+	WizcliVersion          string         `json:"wizcliVersion"`
+	Targets                []string       `json:"targets"`
+	FailedTargets          []FailedTarget `json:"failedTargets,omitempty"`
+	FindingCount           int            `json:"findingCount"`
+	SeverityCounts         map[string]int `json:"severityCounts"`
+	HistoricalFindingCount int            `json:"historicalFindingCount"`
+	UnmappedSeverities     map[string]int `json:"unmappedSeverities,omitempty"`
+	SanityCheck            *SanityCheck   `json:"sanityCheck,omitempty"`
+	PolicyViolations       int            `json:"policyViolations,omitempty"`
+}
+
+// FailedTarget records a scan target that produced no usable output
+type FailedTarget struct {
+	Directory string `json:"directory"`
+	Error     string `json:"error"`
+}
+
+// Snapshot holds everything stored for a single run. Inventory is nil for runs stored
+// before the package inventory was kept.
+type Snapshot struct {
+	Metadata   RunMetadata
+	Current    *VulnerabilityOutput
+	Historical *VulnerabilityOutput
+	Inventory  []InventoryItem
+}
+
+// NewRunID returns a sortable run identifier for the given start time. Microseconds keep runs
+// started within the same second apart.
+func NewRunID(startedAt time.Time) string {
+	return startedAt.UTC().Format("20060102T150405.000000Z")
+}
+
+// countFindings returns the total number of findings and the number per severity
+func countFindings(state *VulnerabilityOutput) (int, map[string]int) {
+	total := 0
+	severityCounts := make(map[string]int)
+
+	if state == nil {
+		return total, severityCounts
+	}
+
+	for _, dataSource := range state.DataSources {
+		for _, asset := range dataSource.Assets {
+			for _, vuln := range asset.VulnerabilityFindings {
+				total++
+				severityCounts[vuln.Severity]++
+			}
+		}
+	}
+
+	return total, severityCounts
+}
+
+// WriteSnapshot stores the current and historical state and the package inventory of a run together
+// with its metadata. Snapshots are immutable, so writing a run ID that already exists is an error.
+// An accepted run becomes the current run, as its states were written to the state files.
+func WriteSnapshot(metadata RunMetadata, currentState, historicalState *VulnerabilityOutput, inventory []InventoryItem) error {
+	if metadata.RunID == "" {
+		return fmt.Errorf("snapshot run ID cannot be empty")
+	}
+
+	runDir := filepath.Join(SnapshotDir, metadata.RunID)
+	if _, err := os.Stat(runDir); err == nil {
+		return fmt.Errorf("snapshot %s already exists", metadata.RunID)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Write into a temporary directory and rename it into place, so a failed write leaves no partial snapshot
+	if err := os.MkdirAll(SnapshotDir, 0755); err != nil {
+		return fmt.Errorf("cannot create snapshot directory: %v", err)
+	}
+	tempDir, err := os.MkdirTemp(SnapshotDir, "."+metadata.RunID+"-")
+	if err != nil {
+		return fmt.Errorf("cannot create snapshot directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir) // Nothing is left to remove once the rename succeeded
+
+	// Fill in the counts so listing snapshots doesn't need to load the states
+	metadata.FindingCount, metadata.SeverityCounts = countFindings(currentState)
+	metadata.HistoricalFindingCount, _ = countFindings(historicalState)
+
+	for name, content := range map[string]interface{}{
+		snapshotMetadataFile:   metadata,
+		snapshotCurrentFile:    currentState,
+		snapshotHistoricalFile: historicalState,
+		snapshotInventoryFile:  inventory,
+	} {
+		data, err := json.MarshalIndent(content, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		// Snapshot files are read-only so they are not edited by accident
+		if err := os.WriteFile(filepath.Join(tempDir, name), data, 0444); err != nil {
+			return fmt.Errorf("cannot write snapshot file %s: %v", name, err)
+		}
+	}
+
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		return fmt.Errorf("cannot create snapshot directory: %v", err)
+	}
+	// Renaming onto an existing, non-empty snapshot fails, so a concurrent run with the same ID can't be overwritten
+	if err := os.Rename(tempDir, runDir); err != nil {
+		return fmt.Errorf("cannot store snapshot %s: %v", metadata.RunID, err)
+	}
+
+	if metadata.SanityCheck.Accepted() {
+		return setCurrentRun(metadata.RunID)
+	}
+	return nil
+}
+
+// setCurrentRun records the run whose states the state files hold
+func setCurrentRun(runID string) error {
+	if err := os.WriteFile(filepath.Join(SnapshotDir, currentRunFile), []byte(runID+"\n"), 0644); err != nil {
+		return fmt.Errorf("cannot record the current run: %v", err)
+	}
+	return nil
+}
+
+// currentRunID returns the run named by the current run marker, empty when there is none
+func currentRunID() (string, error) {
+	data, err := os.ReadFile(filepath.Join(SnapshotDir, currentRunFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// CurrentRun returns the run the state files hold: the one restored by the last rollback, or else the
+// last accepted run. Snapshots written before the current run was recorded fall back to the latest
+// accepted run. It returns nil if there is none.
+func CurrentRun() (*RunMetadata, error) {
+	runID, err := currentRunID()
+	if err != nil {
+		return nil, err
+	}
+	if runID == "" {
+		return LatestAcceptedRun()
+	}
+
+	runs, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for i := range runs {
+		if runs[i].RunID == runID {
+			return &runs[i], nil
+		}
+	}
+	return LatestAcceptedRun() // The marked snapshot was removed by hand
+}
+
+// ListSnapshots returns the metadata of all stored runs, oldest first
+func ListSnapshots() ([]RunMetadata, error) {
+	entries, err := os.ReadDir(SnapshotDir)
+	if os.IsNotExist(err) {
+		return []RunMetadata{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	runs := []RunMetadata{}
+	for _, entry := range entries {
+		// Skip files and the temporary directories of snapshots being written
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		var metadata RunMetadata
+		if err := readJSONFile(filepath.Join(SnapshotDir, entry.Name(), snapshotMetadataFile), &metadata); err != nil {
+			return nil, fmt.Errorf("cannot read snapshot %s: %v", entry.Name(), err)
+		}
+		runs = append(runs, metadata)
+	}
+
+	// Run IDs are timestamps, so sorting them sorts the runs chronologically
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].RunID < runs[j].RunID
+	})
+
+	return runs, nil
+}
+
+// LoadSnapshot reads the snapshot stored for the given run ID
+func LoadSnapshot(runID string) (*Snapshot, error) {
+	if runID == "" || filepath.Base(runID) != runID {
+		return nil, fmt.Errorf("invalid run ID '%s'", runID)
+	}
+
+	runDir := filepath.Join(SnapshotDir, runID)
+	if _, err := os.Stat(runDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("snapshot %s not found", runID)
+	}
+
+	snapshot := &Snapshot{
+		Current:    &VulnerabilityOutput{},
+		Historical: &VulnerabilityOutput{},
+	}
+
+	if err := readJSONFile(filepath.Join(runDir, snapshotMetadataFile), &snapshot.Metadata); err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(filepath.Join(runDir, snapshotCurrentFile), snapshot.Current); err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(filepath.Join(runDir, snapshotHistoricalFile), snapshot.Historical); err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(filepath.Join(runDir, snapshotInventoryFile), &snapshot.Inventory); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// ApplyRetention removes old snapshots so that at most keepRuns accepted runs remain and none is older than
+// keepDays. A value of zero disables the corresponding rule. Runs refused by the sanity check don't count
+// towards keepRuns and are removed once they are older than every kept accepted run, so a series of refused
+// runs never pushes out the baseline. The most recent snapshot, the latest accepted one and the current run
+// are always kept. It returns the run IDs that were removed.
+func ApplyRetention(keepRuns, keepDays int) ([]string, error) {
+	runs, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	currentRun, err := currentRunID()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	cutoff := time.Now().UTC().AddDate(0, 0, -keepDays)
+
+	// Walk the runs newest first, counting the accepted ones
+	expired := make([]bool, len(runs))
+	accepted, latestAccepted := 0, -1
+	oldestKeptAccepted := ""
+	for i := len(runs) - 1; i >= 0; i-- {
+		if !runs[i].SanityCheck.Accepted() {
+			continue
+		}
+		accepted++
+		if accepted == 1 {
+			latestAccepted = i
+		}
+		if keepRuns > 0 && accepted > keepRuns {
+			expired[i] = true
+		} else {
+			oldestKeptAccepted = runs[i].RunID
+		}
+	}
+
+	// Oldest first, all but the newest run
+	for i := 0; i < len(runs)-1; i++ {
+		if i == latestAccepted || runs[i].RunID == currentRun {
+			continue
+		}
+
+		if !runs[i].SanityCheck.Accepted() && keepRuns > 0 && runs[i].RunID < oldestKeptAccepted {
+			expired[i] = true
+		}
+		if keepDays > 0 {
+			startedAt, err := time.Parse(time.RFC3339, runs[i].StartedAt)
+			if err == nil && startedAt.Before(cutoff) {
+				expired[i] = true
+			}
+		}
+
+		if !expired[i] {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(SnapshotDir, runs[i].RunID)); err != nil {
+			return removed, fmt.Errorf("cannot remove snapshot %s: %v", runs[i].RunID, err)
+		}
+		removed = append(removed, runs[i].RunID)
+	}
+
+	return removed, nil
+}
+
+// RollbackState restores the current and historical state files to the ones stored in a snapshot.
+// Runs refused by the sanity check never became history and cannot be restored.
+func RollbackState(runID string) (*Snapshot, error) {
+	snapshot, err := LoadSnapshot(runID)
+	if err != nil {
+		return nil, err
+	}
+	if !snapshot.Metadata.SanityCheck.Accepted() {
+		return nil, fmt.Errorf("run %s was refused by the sanity check and cannot be restored", runID)
+	}
+
+	if err := WriteHistoricalState(snapshot.Historical); err != nil {
+		return nil, fmt.Errorf("error writing historical state: %v", err)
+	}
+
+	if err := WriteCurrentState(snapshot.Current); err != nil {
+		return nil, fmt.Errorf("error writing current state: %v", err)
+	}
+
+	// Exports and the next sanity check use the restored run from now on
+	if err := setCurrentRun(runID); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// readJSONFile reads a JSON file and unmarshals it into v
+func readJSONFile(path string, v interface{}) error {
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(fileContent, v)
+}
@@ -3,7 +3,9 @@ package vulnerability
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 )
 
 // OpenHistoricalState looks for the file "state-historical.json" and opens it if it exists.
@@ -100,26 +102,54 @@ func WriteCurrentState(currentState *VulnerabilityOutput) error {
 	return nil
 }
 
-// UpdateHistoricalState updates the historical state with new vulnerabilities from the current state
+// UpdateHistoricalState updates the historical state with new vulnerabilities from the current state.
+// Findings are merged per asset, assets seen for the first time are added to the history. Findings
+// recorded before first-seen dates were kept get the date of the current state, so it stays fixed.
 func UpdateHistoricalState(historicalState, currentState *VulnerabilityOutput) (*VulnerabilityOutput, error) {
-	// Create a map for quick lookups from historicalState
-	historicalVulnerabilityMap := make(map[string]bool)
+	if len(historicalState.DataSources) == 0 {
+		historicalState.DataSources = []DataSource{{Assets: []Asset{}}}
+	}
+
+	// Create maps for quick lookups from historicalState
+	historicalAssetIndex := make(map[string]int)
+	historicalVulnerabilityMap := make(map[string]int) // Index of the finding in its historical asset
 
-	// Populate the map with data from historicalState
-	for _, asset := range historicalState.DataSources[0].Assets {
-		for _, vuln := range asset.VulnerabilityFindings {
-			historicalVulnerabilityMap[vuln.Description] = true
+	// Populate the maps with data from historicalState
+	for i, asset := range historicalState.DataSources[0].Assets {
+		historicalAssetIndex[AssetKey(asset.AssetIdentifier)] = i
+		for j, vuln := range asset.VulnerabilityFindings {
+			historicalVulnerabilityMap[AssetKey(asset.AssetIdentifier)+"\n"+vuln.Description] = j
 		}
 	}
 
 	// Loop through the vulnerability findings in currentState
-	for _, asset := range currentState.DataSources[0].Assets {
-		for _, vuln := range asset.VulnerabilityFindings {
-			if _, exists := historicalVulnerabilityMap[vuln.Description]; !exists {
-				// This vulnerability is not in historicalState, so add it
-				historicalState.DataSources[0].Assets[0].VulnerabilityFindings = append(historicalState.DataSources[0].Assets[0].VulnerabilityFindings, vuln)
-				// Update the map
-				historicalVulnerabilityMap[vuln.Description] = true
+	for _, dataSource := range currentState.DataSources {
+		for _, asset := range dataSource.Assets {
+			key := AssetKey(asset.AssetIdentifier)
+
+			// Add assets that are not in historicalState yet
+			index, exists := historicalAssetIndex[key]
+			if !exists {
+				historicalState.DataSources[0].Assets = append(historicalState.DataSources[0].Assets, Asset{
+					AssetIdentifier:       asset.AssetIdentifier,
+					VulnerabilityFindings: []VulnerabilityFinding{},
+				})
+				index = len(historicalState.DataSources[0].Assets) - 1
+				historicalAssetIndex[key] = index
+			}
+			historicalAsset := &historicalState.DataSources[0].Assets[index]
+
+			for _, vuln := range asset.VulnerabilityFindings {
+				j, exists := historicalVulnerabilityMap[key+"\n"+vuln.Description]
+				if !exists {
+					// This vulnerability is not in historicalState, so add it
+					historicalAsset.VulnerabilityFindings = append(historicalAsset.VulnerabilityFindings, vuln)
+					// Update the map
+					historicalVulnerabilityMap[key+"\n"+vuln.Description] = len(historicalAsset.VulnerabilityFindings) - 1
+				} else if historicalAsset.VulnerabilityFindings[j].FirstSeen == "" {
+					// Backfill the first-seen date of findings recorded before it was kept
+					historicalAsset.VulnerabilityFindings[j].FirstSeen = vuln.FirstSeen
+				}
 			}
 		}
 	}
@@ -151,3 +181,100 @@ func OpenCurrentState() (*VulnerabilityOutput, error) {
 	// Return the populated or empty historicalState
 	return currentState, nil
 }
+
+// UploadStateFile is the file holding the payload uploaded to Wiz
+const UploadStateFile = "state-upload.json"
+
+// UploadPayload returns a copy of the current state holding only the findings that should be sent to Wiz.
+// Suppressed findings and findings VEX marks as not_affected or fixed stay in the state files but are left
+// out of the upload. Other VEX statuses and the prioritization data are noted in the uploaded description,
+// as only the fields of the upload schema are sent.
+func UploadPayload(currentState *VulnerabilityOutput) *VulnerabilityOutput {
+	payload := &VulnerabilityOutput{
+		IntegrationID: currentState.IntegrationID,
+		DataSources:   []DataSource{},
+	}
+
+	for _, dataSource := range currentState.DataSources {
+		uploadDataSource := DataSource{
+			ID:           dataSource.ID,
+			AnalysisDate: dataSource.AnalysisDate,
+			Assets:       []Asset{},
+		}
+
+		for _, asset := range dataSource.Assets {
+			uploadAsset := Asset{
+				AssetIdentifier:       asset.AssetIdentifier,
+				VulnerabilityFindings: []VulnerabilityFinding{},
+			}
+			for _, vuln := range asset.VulnerabilityFindings {
+				if vuln.Suppression != nil || vuln.VEX.Excluded() {
+					continue
+				}
+				if vuln.VEX != nil {
+					vuln.Description += fmt.Sprintf(".  VEX status %s according to %s", vuln.VEX.Status, filepath.Base(vuln.VEX.Source))
+					if vuln.VEX.Detail != "" {
+						vuln.Description += ": " + vuln.VEX.Detail
+					}
+				}
+				vuln.Description += prioritizationNote(vuln)
+				uploadAsset.VulnerabilityFindings = append(uploadAsset.VulnerabilityFindings, VulnerabilityFinding{
+					ID:                      vuln.ID,
+					Name:                    vuln.Name,
+					DetailedName:            vuln.DetailedName,
+					ExternalDetectionSource: vuln.ExternalDetectionSource,
+					Severity:                vuln.Severity,
+					ExternalFindingLink:     vuln.ExternalFindingLink,
+					Version:                 vuln.Version,
+					Source:                  vuln.Source,
+					Remediation:             vuln.Remediation,
+					FixedVersion:            vuln.FixedVersion,
+					ValidatedAtRuntime:      vuln.ValidatedAtRuntime,
+					Description:             vuln.Description,
+				})
+			}
+			uploadDataSource.Assets = append(uploadDataSource.Assets, uploadAsset)
+		}
+
+		payload.DataSources = append(payload.DataSources, uploadDataSource)
+	}
+
+	return payload
+}
+
+// prioritizationNote describes the CVSS, EPSS and KEV data of a finding for the uploaded description
+func prioritizationNote(vuln VulnerabilityFinding) string {
+	var note string
+	if vuln.CVSSScore != 0 || vuln.CVSSVector != "" {
+		note += fmt.Sprintf(".  CVSS score %.1f", vuln.CVSSScore)
+		if vuln.CVSSVector != "" {
+			note += ", vector " + vuln.CVSSVector
+		}
+	}
+	if vuln.ExploitabilityScore != 0 {
+		note += fmt.Sprintf(".  Exploitability score %.1f", vuln.ExploitabilityScore)
+	}
+	if vuln.EPSSProbability != 0 || vuln.EPSSPercentile != 0 {
+		note += fmt.Sprintf(".  EPSS probability %.5f, percentile %.5f", vuln.EPSSProbability, vuln.EPSSPercentile)
+	}
+	if vuln.KnownExploited != nil && *vuln.KnownExploited {
+		note += ".  Known exploited (CISA KEV"
+		if vuln.KEVDateAdded != "" {
+			note += ", added " + vuln.KEVDateAdded
+		}
+		note += ")"
+	}
+	return note
+}
+
+// WriteUploadState writes the upload payload to UploadStateFile.
+func WriteUploadState(payload *VulnerabilityOutput) error {
+	// Convert the payload to JSON
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write the data to the file
+	return os.WriteFile(UploadStateFile, data, 0644)
+}
@@ -0,0 +1,132 @@
+package vulnerability
+
+import (
+	"os"
+	"path/filepath"
+	"scanapp/pkg/config"
+	"scanapp/pkg/wizcli"
+	"strings"
+	"testing"
+)
+
+// singleFindingState returns a state holding one finding on one asset
+func singleFindingState(finding VulnerabilityFinding) *VulnerabilityOutput {
+	return &VulnerabilityOutput{
+		IntegrationID: "integration",
+		DataSources: []DataSource{{
+			ID: "data-source",
+			Assets: []Asset{{
+				AssetIdentifier:       AssetIdentifier{CloudPlatform: "AWS", ProviderId: "i-0123"},
+				VulnerabilityFindings: []VulnerabilityFinding{finding},
+			}},
+		}},
+	}
+}
+
+func TestUploadPayloadCarriesPrioritizationData(t *testing.T) {
+	nvdFile := filepath.Join(t.TempDir(), "nvdcve-2.0-2021.json")
+	nvdFeed := `{"vulnerabilities": [{"cve": {"id": "CVE-2021-44228", "metrics": {"cvssMetricV31": [
+		{"type": "Secondary", "cvssData": {"vectorString": "CVSS:3.1/AV:L/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", "baseScore": 1.8}},
+		{"type": "Primary", "cvssData": {"vectorString": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", "baseScore": 10.0}}
+	]}}}]}`
+	if err := os.WriteFile(nvdFile, []byte(nvdFeed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nvd, err := LoadNVD(nvdFile)
+	if err != nil {
+		t.Fatalf("LoadNVD returned error: %v", err)
+	}
+
+	state := singleFindingState(VulnerabilityFinding{
+		Name:                "CVE-2021-44228",
+		DetailedName:        "log4j-core",
+		Description:         "log4j-core was detected",
+		CVSSScore:           9.8,
+		ExploitabilityScore: 3.9,
+	})
+	epss := map[string]EPSSScore{"CVE-2021-44228": {Probability: 0.97565, Percentile: 0.99996}}
+	kev := map[string]KEVEntry{"CVE-2021-44228": {CveID: "CVE-2021-44228", DateAdded: "2021-12-10"}}
+	if enriched := EnrichFindings(state, nvd, epss, kev); enriched != 1 {
+		t.Fatalf("enriched = %d, want 1", enriched)
+	}
+
+	finding := state.DataSources[0].Assets[0].VulnerabilityFindings[0]
+	if finding.CVSSVector != "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H" {
+		t.Errorf("vector = %q, want the primary NVD vector", finding.CVSSVector)
+	}
+	if finding.CVSSScore != 9.8 {
+		t.Errorf("score = %.1f, the wizcli score should be kept", finding.CVSSScore)
+	}
+
+	payload := UploadPayload(state)
+	description := payload.DataSources[0].Assets[0].VulnerabilityFindings[0].Description
+	for _, want := range []string{
+		"log4j-core was detected",
+		"CVSS score 9.8, vector CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H",
+		"Exploitability score 3.9",
+		"EPSS probability 0.97565, percentile 0.99996",
+		"Known exploited (CISA KEV, added 2021-12-10)",
+	} {
+		if !strings.Contains(description, want) {
+			t.Errorf("uploaded description %q does not contain %q", description, want)
+		}
+	}
+
+	// The state itself keeps the original description
+	if finding.Description != "log4j-core was detected" {
+		t.Errorf("state description changed to %q", finding.Description)
+	}
+}
+
+func TestUploadPayloadWithoutPrioritizationData(t *testing.T) {
+	state := singleFindingState(VulnerabilityFinding{Name: "CVE-2024-0001", Description: "curl was detected"})
+
+	payload := UploadPayload(state)
+	if got := payload.DataSources[0].Assets[0].VulnerabilityFindings[0].Description; got != "curl was detected" {
+		t.Errorf("description = %q, want it unchanged", got)
+	}
+}
+
+func TestUpdateHistoricalStateBackfillsFirstSeen(t *testing.T) {
+	cfg := &config.Config{ScanCloudType: "AWS", ScanProviderID: "i-0123"}
+	scanResults := []wizcli.ScanResult{{
+		Directory: "/",
+		JSON: `{"result": {"osPackages": [{"name": "curl", "version": "7.88.1", "path": "/usr/bin/curl", "detectionMethod": "PACKAGE",
+			"vulnerabilities": [{"name": "CVE-2023-38545", "severity": "CRITICAL", "fixedVersion": "8.4.0"}]}]}}`,
+	}}
+
+	// History written before first-seen dates were recorded
+	legacy, _, err := ProcessVulnerabilities(scanResults, cfg, &VulnerabilityOutput{}, nil, nil)
+	if err != nil {
+		t.Fatalf("ProcessVulnerabilities returned error: %v", err)
+	}
+	legacy.DataSources[0].Assets[0].VulnerabilityFindings[0].FirstSeen = ""
+	history := legacy
+
+	var firstSeen []string
+	for run := 1; run <= 2; run++ {
+		current, _, err := ProcessVulnerabilities(scanResults, cfg, history, nil, nil)
+		if err != nil {
+			t.Fatalf("run %d: ProcessVulnerabilities returned error: %v", run, err)
+		}
+		if history, err = UpdateHistoricalState(history, current); err != nil {
+			t.Fatalf("run %d: UpdateHistoricalState returned error: %v", run, err)
+		}
+
+		recorded := history.DataSources[0].Assets[0].VulnerabilityFindings[0].FirstSeen
+		if recorded == "" {
+			t.Fatalf("run %d: the first-seen date was not written to the history", run)
+		}
+		if reported := current.DataSources[0].Assets[0].VulnerabilityFindings[0].FirstSeen; reported != recorded {
+			t.Errorf("run %d: reported first seen %s, history holds %s", run, reported, recorded)
+		}
+		firstSeen = append(firstSeen, recorded)
+	}
+
+	if firstSeen[0] != firstSeen[1] {
+		t.Errorf("first seen changed from %s to %s between runs", firstSeen[0], firstSeen[1])
+	}
+	if findings := history.DataSources[0].Assets[0].VulnerabilityFindings; len(findings) != 1 {
+		t.Errorf("history holds %d findings, want 1", len(findings))
+	}
+}
@@ -0,0 +1,143 @@
+// in suppression.go
+package vulnerability
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// suppressionDateLayout is the layout of the expiry date in the suppression file
+const suppressionDateLayout = "2006-01-02"
+
+// Suppression is an accepted-risk or false-positive rule. Every field that is set must match a
+// finding for the rule to apply. Path and package accept glob patterns, a path ending in "/**"
+// matches everything below it.
+type Suppression struct {
+	CVE           string `json:"cve,omitempty"`
+	Package       string `json:"package,omitempty"`
+	Path          string `json:"path,omitempty"`
+	Asset         string `json:"asset,omitempty"`
+	Justification string `json:"justification"`
+	Owner         string `json:"owner"`
+	Expires       string `json:"expires"`
+}
+
+// SuppressionInfo is recorded on a suppressed finding
+type SuppressionInfo struct {
+	Justification string `json:"justification"`
+	Owner         string `json:"owner"`
+	Expires       string `json:"expires"`
+}
+
+// Validate checks that the rule matches something and carries its mandatory fields
+func (s Suppression) Validate() error {
+	if s.CVE == "" && s.Package == "" && s.Path == "" && s.Asset == "" {
+		return fmt.Errorf("suppression must set at least one of cve, package, path or asset")
+	}
+	if strings.TrimSpace(s.Justification) == "" {
+		return fmt.Errorf("suppression %s must have a justification", s)
+	}
+	if strings.TrimSpace(s.Owner) == "" {
+		return fmt.Errorf("suppression %s must have an owner", s)
+	}
+	if _, err := time.Parse(suppressionDateLayout, s.Expires); err != nil {
+		return fmt.Errorf("suppression %s must have an expiry date in YYYY-MM-DD format", s)
+	}
+	for _, pattern := range []string{s.Package, s.Path} {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+			return fmt.Errorf("suppression %s has an invalid pattern '%s'", s, pattern)
+		}
+	}
+	return nil
+}
+
+// String describes the rule by its matching criteria
+func (s Suppression) String() string {
+	var criteria []string
+	for _, criterion := range []struct{ name, value string }{
+		{"cve", s.CVE}, {"package", s.Package}, {"path", s.Path}, {"asset", s.Asset},
+	} {
+		if criterion.value != "" {
+			criteria = append(criteria, criterion.name+"="+criterion.value)
+		}
+	}
+	return "[" + strings.Join(criteria, " ") + "]"
+}
+
+// Expired reports whether the rule is past its expiry date. A rule is valid through its expiry date.
+func (s Suppression) Expired(now time.Time) bool {
+	expires, err := time.Parse(suppressionDateLayout, s.Expires)
+	if err != nil {
+		return true
+	}
+	return !now.Before(expires.AddDate(0, 0, 1))
+}
+
+// matchGlob matches value against a glob pattern, where a trailing "/**" matches a whole subtree
+func matchGlob(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/**") {
+		return isUnder(value, strings.TrimSuffix(pattern, "/**"))
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+// Matches reports whether the rule applies to a finding of the given asset
+func (s Suppression) Matches(identifier AssetIdentifier, finding VulnerabilityFinding) bool {
+	if s.CVE != "" && !strings.EqualFold(s.CVE, finding.Name) {
+		return false
+	}
+	if s.Asset != "" && s.Asset != identifier.ProviderId {
+		return false
+	}
+	return matchGlob(s.Package, finding.DetailedName) && matchGlob(s.Path, FindingPath(finding))
+}
+
+// LoadSuppressions reads and validates a suppression file, a JSON array of rules
+func LoadSuppressions(filePath string) ([]Suppression, error) {
+	var suppressions []Suppression
+	if err := readJSONFile(filePath, &suppressions); err != nil {
+		return nil, fmt.Errorf("cannot read suppression file: %v", err)
+	}
+
+	for _, suppression := range suppressions {
+		if err := suppression.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return suppressions, nil
+}
+
+// activeSuppressions splits the rules into the ones still in force and the expired ones
+func activeSuppressions(suppressions []Suppression, now time.Time) ([]Suppression, []Suppression) {
+	var active, expired []Suppression
+	for _, suppression := range suppressions {
+		if suppression.Expired(now) {
+			expired = append(expired, suppression)
+		} else {
+			active = append(active, suppression)
+		}
+	}
+	return active, expired
+}
+
+// applySuppressions marks the finding as suppressed by the first matching rule and reports whether one matched
+func applySuppressions(suppressions []Suppression, identifier AssetIdentifier, finding *VulnerabilityFinding) bool {
+	for _, suppression := range suppressions {
+		if suppression.Matches(identifier, *finding) {
+			finding.Suppression = &SuppressionInfo{
+				Justification: suppression.Justification,
+				Owner:         suppression.Owner,
+				Expires:       suppression.Expires,
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+// in vex.go
+package vulnerability
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// VEX statuses, following the OpenVEX names
+const (
+	VEXNotAffected        = "not_affected"
+	VEXAffected           = "affected"
+	VEXFixed              = "fixed"
+	VEXUnderInvestigation = "under_investigation"
+)
+
+// VEXStatement is a single VEX statement about a vulnerability in a set of products.
+// An empty product list applies the statement to every product.
+type VEXStatement struct {
+	Vulnerability string
+	Products      []string
+	Status        string
+	Justification string
+	Detail        string
+	Source        string
+}
+
+// VEXInfo is recorded on a finding a VEX statement applies to
+type VEXInfo struct {
+	Status        string `json:"status"`
+	Justification string `json:"justification,omitempty"`
+	Detail        string `json:"detail,omitempty"`
+	Source        string `json:"source"`
+}
+
+// validVEXStatus reports whether status is one of the OpenVEX statuses
+func validVEXStatus(status string) bool {
+	switch status {
+	case VEXNotAffected, VEXAffected, VEXFixed, VEXUnderInvestigation:
+		return true
+	default:
+		return false
+	}
+}
+
+// Excluded reports whether findings with this VEX status are left out of the upload
+func (v *VEXInfo) Excluded() bool {
+	return v != nil && (v.Status == VEXNotAffected || v.Status == VEXFixed)
+}
+
+// openVEXDocument is the subset of an OpenVEX document used here
+type openVEXDocument struct {
+	Context    string `json:"@context"`
+	Statements []struct {
+		Vulnerability   json.RawMessage   `json:"vulnerability"`
+		Products        []json.RawMessage `json:"products"`
+		Status          string            `json:"status"`
+		Justification   string            `json:"justification"`
+		ImpactStatement string            `json:"impact_statement"`
+		StatusNotes     string            `json:"status_notes"`
+	} `json:"statements"`
+}
+
+// cycloneDXDocument is the subset of a CycloneDX BOM with VEX data used here
+type cycloneDXDocument struct {
+	BOMFormat  string `json:"bomFormat"`
+	Components []struct {
+		BOMRef string `json:"bom-ref"`
+		Name   string `json:"name"`
+		PURL   string `json:"purl"`
+	} `json:"components"`
+	Vulnerabilities []struct {
+		ID       string `json:"id"`
+		Analysis struct {
+			State         string `json:"state"`
+			Justification string `json:"justification"`
+			Detail        string `json:"detail"`
+		} `json:"analysis"`
+		Affects []struct {
+			Ref string `json:"ref"`
+		} `json:"affects"`
+	} `json:"vulnerabilities"`
+}
+
+// cycloneDXStates maps CycloneDX analysis states to VEX statuses
+var cycloneDXStates = map[string]string{
+	"not_affected":           VEXNotAffected,
+	"false_positive":         VEXNotAffected,
+	"resolved":               VEXFixed,
+	"resolved_with_pedigree": VEXFixed,
+	"exploitable":            VEXAffected,
+	"in_triage":              VEXUnderInvestigation,
+}
+
+// LoadVEXFiles reads the statements of every VEX document, later documents take precedence
+func LoadVEXFiles(paths []string) ([]VEXStatement, error) {
+	var statements []VEXStatement
+	for _, path := range paths {
+		documentStatements, err := LoadVEX(path)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, documentStatements...)
+	}
+	return statements, nil
+}
+
+// LoadVEX reads an OpenVEX or CycloneDX VEX document and returns its statements
+func LoadVEX(path string) ([]VEXStatement, error) {
+	var probe struct {
+		Context   string `json:"@context"`
+		BOMFormat string `json:"bomFormat"`
+	}
+	if err := readJSONFile(path, &probe); err != nil {
+		return nil, fmt.Errorf("cannot read VEX document %s: %v", path, err)
+	}
+
+	switch {
+	case strings.Contains(probe.Context, "openvex"):
+		return loadOpenVEX(path)
+	case probe.BOMFormat == "CycloneDX":
+		return loadCycloneDXVEX(path)
+	default:
+		return nil, fmt.Errorf("VEX document %s is neither OpenVEX nor CycloneDX", path)
+	}
+}
+
+// loadOpenVEX reads the statements of an OpenVEX document
+func loadOpenVEX(path string) ([]VEXStatement, error) {
+	var document openVEXDocument
+	if err := readJSONFile(path, &document); err != nil {
+		return nil, fmt.Errorf("cannot read VEX document %s: %v", path, err)
+	}
+
+	var statements []VEXStatement
+	for i, raw := range document.Statements {
+		// The vulnerability is either a plain name or an object with a name
+		vulnerability := rawName(raw.Vulnerability, "name")
+
+		// An unknown status would silently count as not excluding the finding
+		if !validVEXStatus(raw.Status) {
+			return nil, fmt.Errorf("VEX document %s statement %d (%s) has status '%s', expected %s, %s, %s or %s",
+				path, i+1, vulnerability, raw.Status, VEXNotAffected, VEXAffected, VEXFixed, VEXUnderInvestigation)
+		}
+
+		var products []string
+		for _, product := range raw.Products {
+			products = append(products, rawName(product, "@id"))
+		}
+
+		detail := raw.ImpactStatement
+		if detail == "" {
+			detail = raw.StatusNotes
+		}
+
+		statements = append(statements, VEXStatement{
+			Vulnerability: vulnerability,
+			Products:      products,
+			Status:        raw.Status,
+			Justification: raw.Justification,
+			Detail:        detail,
+			Source:        path,
+		})
+	}
+
+	return statements, nil
+}
+
+// loadCycloneDXVEX reads the vulnerability analyses of a CycloneDX document
+func loadCycloneDXVEX(path string) ([]VEXStatement, error) {
+	var document cycloneDXDocument
+	if err := readJSONFile(path, &document); err != nil {
+		return nil, fmt.Errorf("cannot read VEX document %s: %v", path, err)
+	}
+
+	// Affected references point at components, resolve them to purls where possible
+	refs := make(map[string]string)
+	for _, component := range document.Components {
+		if component.PURL != "" {
+			refs[component.BOMRef] = component.PURL
+		} else {
+			refs[component.BOMRef] = component.Name
+		}
+	}
+
+	var statements []VEXStatement
+	for _, vuln := range document.Vulnerabilities {
+		if vuln.Analysis.State == "" {
+			continue // Vulnerabilities without analysis carry no VEX information
+		}
+		status, known := cycloneDXStates[vuln.Analysis.State]
+		if !known {
+			return nil, fmt.Errorf("VEX document %s vulnerability %s has unknown analysis state '%s'", path, vuln.ID, vuln.Analysis.State)
+		}
+
+		var products []string
+		for _, affected := range vuln.Affects {
+			if resolved, exists := refs[affected.Ref]; exists {
+				products = append(products, resolved)
+			} else {
+				products = append(products, affected.Ref)
+			}
+		}
+
+		statements = append(statements, VEXStatement{
+			Vulnerability: vuln.ID,
+			Products:      products,
+			Status:        status,
+			Justification: vuln.Analysis.Justification,
+			Detail:        vuln.Analysis.Detail,
+			Source:        path,
+		})
+	}
+
+	return statements, nil
+}
+
+// rawName returns a JSON string, or the given field of a JSON object
+func rawName(raw json.RawMessage, field string) string {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return name
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(raw, &object); err == nil {
+		if name, ok := object[field].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// parseProduct returns the package name and version of a product identifier.
+// Package URLs (pkg:type/namespace/name@version) are decoded, other identifiers are
+// taken as "name" or "name@version".
+func parseProduct(product string) (string, string) {
+	product = strings.TrimPrefix(product, "pkg:")
+
+	// Drop qualifiers and subpath
+	if i := strings.IndexAny(product, "?#"); i != -1 {
+		product = product[:i]
+	}
+
+	name, version := product, ""
+	if i := strings.LastIndex(product, "@"); i > 0 {
+		name, version = product[:i], product[i+1:]
+	}
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	if unescaped, err := url.PathUnescape(version); err == nil {
+		version = unescaped
+	}
+	return name, version
+}
+
+// Matches reports whether the statement applies to the finding
+func (s VEXStatement) Matches(finding VulnerabilityFinding) bool {
+	if !strings.EqualFold(s.Vulnerability, finding.Name) {
+		return false
+	}
+	if len(s.Products) == 0 {
+		return true
+	}
+
+	// Package names like "group:artifact" or "namespace/name" are also matched by their last part
+	packageName := finding.DetailedName
+	if i := strings.LastIndexAny(packageName, ":/"); i != -1 {
+		packageName = packageName[i+1:]
+	}
+
+	for _, product := range s.Products {
+		name, version := parseProduct(product)
+		nameMatches := strings.EqualFold(name, finding.DetailedName) || strings.EqualFold(name, packageName)
+		if nameMatches && (version == "" || version == finding.Version) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyVEX records the last matching statement on the finding and reports whether one matched
+func applyVEX(statements []VEXStatement, finding *VulnerabilityFinding) bool {
+	matched := false
+	for _, statement := range statements {
+		if statement.Matches(*finding) {
+			finding.VEX = &VEXInfo{
+				Status:        statement.Status,
+				Justification: statement.Justification,
+				Detail:        statement.Detail,
+				Source:        statement.Source,
+			}
+			matched = true
+		}
+	}
+	return matched
+}
@@ -0,0 +1,153 @@
+// File: pkg/wizapi/graphql.go
+
+package wizapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Operation is a GraphQL query together with the type its "data" member decodes into
+type Operation[T any] struct {
+	Name  string // Operation name, used in error messages
+	Query string // The GraphQL query string
+}
+
+// GraphQLError is a single entry of the "errors" member of a GraphQL response
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Code returns the error code Wiz reports in the extensions, if any
+func (e GraphQLError) Code() string {
+	code, _ := e.Extensions["code"].(string)
+	return code
+}
+
+// Error describes the error with its path and code
+func (e GraphQLError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	if len(e.Path) > 0 {
+		var path []string
+		for _, segment := range e.Path {
+			path = append(path, fmt.Sprint(segment))
+		}
+		fmt.Fprintf(&b, " (path %s)", strings.Join(path, "."))
+	}
+	if code := e.Code(); code != "" {
+		fmt.Fprintf(&b, " [%s]", code)
+	}
+	return b.String()
+}
+
+// GraphQLErrors is returned when a response carries errors, along with any partial data
+type GraphQLErrors struct {
+	Operation string
+	Errors    []GraphQLError
+}
+
+// Error lists every error of the response
+func (e *GraphQLErrors) Error() string {
+	var messages []string
+	for _, graphQLError := range e.Errors {
+		messages = append(messages, graphQLError.Error())
+	}
+	return fmt.Sprintf("graphql errors in %s: %s", e.Operation, strings.Join(messages, "; "))
+}
+
+// HasCode reports whether any of the errors carries the given extension code
+func (e *GraphQLErrors) HasCode(code string) bool {
+	for _, graphQLError := range e.Errors {
+		if graphQLError.Code() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// HasMessage reports whether any of the error messages contains the given text
+func (e *GraphQLErrors) HasMessage(text string) bool {
+	for _, graphQLError := range e.Errors {
+		if strings.Contains(graphQLError.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPError is returned when the API answers with a non-2xx status and no GraphQL errors
+type HTTPError struct {
+	Operation  string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+// Error describes the status and the start of the body
+func (e *HTTPError) Error() string {
+	body := e.Body
+	if len(body) > 512 {
+		body = body[:512] + "..."
+	}
+	return fmt.Sprintf("%s failed with status %s: %s", e.Operation, e.Status, body)
+}
+
+// ErrNotFound is returned when an operation finds no resource with the given ID
+var ErrNotFound = errors.New("resource not found")
+
+// IsNotFound reports whether the error says the requested resource doesn't exist
+func IsNotFound(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	var graphQLErrors *GraphQLErrors
+	if errors.As(err, &graphQLErrors) {
+		return graphQLErrors.HasCode("NOT_FOUND") || graphQLErrors.HasMessage("Resource not found")
+	}
+	var httpError *HTTPError
+	return errors.As(err, &httpError) && httpError.StatusCode == 404
+}
+
+// Do runs a GraphQL operation and decodes its data. When the response carries GraphQL errors
+// the partially decoded data is returned together with a *GraphQLErrors.
+func Do[T any](ctx context.Context, w *WizAPI, op Operation[T], variables map[string]interface{}) (T, error) {
+	var result struct {
+		Data   T              `json:"data"`
+		Errors []GraphQLError `json:"errors"`
+	}
+
+	response, err := w.QueryWithRetryContext(ctx, op.Query, variables)
+	if err != nil {
+		return result.Data, fmt.Errorf("error querying %s: %w", op.Name, err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return result.Data, fmt.Errorf("error reading %s response: %w", op.Name, err)
+	}
+
+	success := response.StatusCode >= 200 && response.StatusCode < 300
+	if err := json.Unmarshal(body, &result); err != nil {
+		if !success {
+			return result.Data, &HTTPError{Operation: op.Name, StatusCode: response.StatusCode, Status: response.Status, Body: string(body)}
+		}
+		return result.Data, fmt.Errorf("error unmarshaling %s response: %w", op.Name, err)
+	}
+
+	if len(result.Errors) > 0 {
+		return result.Data, &GraphQLErrors{Operation: op.Name, Errors: result.Errors}
+	}
+	if !success {
+		return result.Data, &HTTPError{Operation: op.Name, StatusCode: response.StatusCode, Status: response.Status, Body: string(body)}
+	}
+
+	return result.Data, nil
+}
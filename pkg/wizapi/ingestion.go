@@ -0,0 +1,72 @@
+package wizapi
+
+// Statuses of a system activity
+const (
+	ActivityInProgress = "IN_PROGRESS"
+	ActivitySuccess    = "SUCCESS"
+	ActivityFailure    = "FAILURE"
+)
+
+// IngestionOutcome summarizes how much of an upload Wiz ingested
+type IngestionOutcome string
+
+const (
+	// IngestionComplete means every incoming item was handled and every asset resolved
+	IngestionComplete IngestionOutcome = "complete"
+	// IngestionPartial means the activity succeeded but items were dropped or assets were not resolved
+	IngestionPartial IngestionOutcome = "partial"
+	// IngestionFailed means the activity failed
+	IngestionFailed IngestionOutcome = "failed"
+	// IngestionPending means the activity is still in progress
+	IngestionPending IngestionOutcome = "pending"
+)
+
+// IngestionStat is one incoming/handled pair of a system activity result
+type IngestionStat struct {
+	Name string
+	IngestionStatsDetails
+}
+
+// Dropped returns how many incoming items were not handled
+func (s IngestionStat) Dropped() int {
+	if s.Handled >= s.Incoming {
+		return 0
+	}
+	return s.Incoming - s.Handled
+}
+
+// Stats returns the incoming/handled pairs of the activity result
+func (a *SystemActivity) Stats() []IngestionStat {
+	return []IngestionStat{
+		{Name: "Data sources", IngestionStatsDetails: a.Result.DataSources},
+		{Name: "Findings", IngestionStatsDetails: a.Result.Findings},
+		{Name: "Events", IngestionStatsDetails: a.Result.Events},
+		{Name: "Tags", IngestionStatsDetails: a.Result.Tags},
+	}
+}
+
+// UnresolvedAssetIDs returns the IDs of the uploaded assets Wiz could not match to a resource
+func (a *SystemActivity) UnresolvedAssetIDs() []string {
+	return a.Result.UnresolvedAssets.IDs
+}
+
+// Outcome classifies the activity by its status and result
+func (a *SystemActivity) Outcome() IngestionOutcome {
+	switch a.Status {
+	case ActivitySuccess:
+	case ActivityInProgress:
+		return IngestionPending
+	default:
+		return IngestionFailed
+	}
+
+	if a.Result.UnresolvedAssets.Count > 0 || len(a.Result.UnresolvedAssets.IDs) > 0 {
+		return IngestionPartial
+	}
+	for _, stat := range a.Stats() {
+		if stat.Dropped() > 0 {
+			return IngestionPartial
+		}
+	}
+	return IngestionComplete
+}
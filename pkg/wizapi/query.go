@@ -0,0 +1,159 @@
+// File: pkg/wizapi/query.go
+
+package wizapi
+
+import "strings"
+
+// Entity types commonly searched for
+const (
+	EntityVirtualMachine = "VIRTUAL_MACHINE"
+	EntityContainer      = "CONTAINER"
+	EntityContainerImage = "CONTAINER_IMAGE"
+	EntityServerless     = "SERVERLESS"
+	EntitySubscription   = "SUBSCRIPTION"
+)
+
+// Property operators of graph search filters
+const (
+	OpEquals         = "EQUALS"
+	OpNotEquals      = "NOT_EQUALS"
+	OpContains       = "CONTAINS"
+	OpStartsWith     = "STARTS_WITH"
+	OpEndsWith       = "ENDS_WITH"
+	OpGreaterThan    = "GREATER_THAN"
+	OpLessThan       = "LESS_THAN"
+	OpIsSet          = "IS_SET"
+	OpTagContainsAny = "TAG_CONTAINS_ANY"
+)
+
+// Relationship types of graph search filters
+const (
+	RelationshipContains = "CONTAINS"
+	RelationshipRuns     = "RUNS"
+	RelationshipUses     = "USES"
+	RelationshipServes   = "SERVES"
+)
+
+// Query builds a graph search query: entity types, property predicates, nested relationships and project scope.
+//
+//	query := wizapi.NewQuery(wizapi.EntityContainer).
+//		Where("name", wizapi.OpContains, "nginx").
+//		Related(wizapi.RelationshipRuns, true, wizapi.NewQuery(wizapi.EntityVirtualMachine).WhereEquals("externalId", "i-123"))
+type Query struct {
+	types         []string
+	where         map[string]map[string]interface{}
+	relationships []relationship
+	projectID     string
+}
+
+// relationship is a related entity the matches must have
+type relationship struct {
+	relationshipType string
+	reverse          bool
+	with             *Query
+}
+
+// NewQuery starts a query for entities of the given types
+func NewQuery(types ...string) *Query {
+	return &Query{types: types, where: make(map[string]map[string]interface{})}
+}
+
+// Where requires a property to match the operator, values of the same property and operator accumulate
+func (q *Query) Where(property, operator string, values ...interface{}) *Query {
+	predicate, exists := q.where[property]
+	if !exists {
+		predicate = make(map[string]interface{})
+		q.where[property] = predicate
+	}
+
+	switch operator {
+	case OpIsSet:
+		predicate[operator] = true
+	case OpGreaterThan, OpLessThan:
+		if len(values) > 0 {
+			predicate[operator] = values[0]
+		}
+	default:
+		existing, _ := predicate[operator].([]interface{})
+		predicate[operator] = append(existing, values...)
+	}
+	return q
+}
+
+// WhereEquals requires a property to equal one of the values
+func (q *Query) WhereEquals(property string, values ...string) *Query {
+	for _, value := range values {
+		q.Where(property, OpEquals, value)
+	}
+	return q
+}
+
+// WhereTag requires the entity to carry the tag, an empty value matches any value
+func (q *Query) WhereTag(key, value string) *Query {
+	tag := map[string]interface{}{"key": key}
+	if value != "" {
+		tag["value"] = value
+	}
+	return q.Where("tags", OpTagContainsAny, tag)
+}
+
+// Related requires a related entity matching the nested query. reverse follows the relationship
+// from the related entity to the match, e.g. a virtual machine that RUNS the container.
+func (q *Query) Related(relationshipType string, reverse bool, with *Query) *Query {
+	q.relationships = append(q.relationships, relationship{relationshipType: relationshipType, reverse: reverse, with: with})
+	return q
+}
+
+// InProject scopes the search to a project, all projects when not called
+func (q *Query) InProject(projectID string) *Query {
+	q.projectID = projectID
+	return q
+}
+
+// ProjectID returns the project the search is scoped to
+func (q *Query) ProjectID() string {
+	if q.projectID == "" {
+		return "*"
+	}
+	return q.projectID
+}
+
+// Build returns the GraphEntityQueryInput of the query
+func (q *Query) Build() map[string]interface{} {
+	input := map[string]interface{}{
+		"type":   q.types,
+		"select": true,
+	}
+	if len(q.where) > 0 {
+		where := make(map[string]interface{})
+		for property, predicate := range q.where {
+			where[property] = predicate
+		}
+		input["where"] = where
+	}
+	if len(q.relationships) > 0 {
+		var relationships []interface{}
+		for _, related := range q.relationships {
+			with := related.with.Build()
+			delete(with, "select") // Only the top-level entities are returned
+			relationships = append(relationships, map[string]interface{}{
+				"type": []interface{}{map[string]interface{}{"type": related.relationshipType, "reverse": related.reverse}},
+				"with": with,
+			})
+		}
+		input["relationships"] = relationships
+	}
+	return input
+}
+
+// String describes the query for log messages
+func (q *Query) String() string {
+	return strings.Join(q.types, ",")
+}
+
+// VMQuery returns the query for virtual machines with the given cloud platform and provider ID
+func VMQuery(cloudPlatform, providerID string) *Query {
+	return NewQuery(EntityVirtualMachine).
+		WhereEquals("cloudPlatform", cloudPlatform).
+		WhereEquals("externalId", providerID)
+}
@@ -0,0 +1,300 @@
+// File: pkg/wizapi/search.go
+
+package wizapi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultPageSize is the number of entities requested per graph search page
+const DefaultPageSize = 50
+
+// DefaultHostnameTags are the tags checked for the host name when disambiguating virtual machines
+var DefaultHostnameTags = []string{"hostname", "Hostname", "Name", "name"}
+
+// GraphSearchPage runs a graph search for a single page, after is the end cursor of the previous page
+func (w *WizAPI) GraphSearchPage(ctx context.Context, query *Query, pageSize int, after string) (*GraphSearchResult, error) {
+	variables := map[string]interface{}{
+		"quick":           true,
+		"first":           pageSize,
+		"query":           query.Build(),
+		"projectId":       query.ProjectID(),
+		"fetchTotalCount": true,
+	}
+	if after != "" {
+		variables["after"] = after
+	}
+
+	data, err := Do(ctx, w, graphSearchOperation, variables)
+	if err != nil {
+		return nil, err
+	}
+	return &data.GraphSearch, nil
+}
+
+// GraphSearchIterator walks the entities of a graph search page by page:
+//
+//	it := client.SearchEntities(query, 0)
+//	for it.Next(ctx) {
+//		entity := it.Entity()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type GraphSearchIterator struct {
+	client     *WizAPI
+	query      *Query
+	pageSize   int
+	cursor     string
+	done       bool
+	entities   []GraphEntity
+	current    GraphEntity
+	totalCount int
+	err        error
+}
+
+// SearchEntities returns an iterator over the entities matching the query, pageSize zero uses DefaultPageSize
+func (w *WizAPI) SearchEntities(query *Query, pageSize int) *GraphSearchIterator {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &GraphSearchIterator{client: w, query: query, pageSize: pageSize}
+}
+
+// Next advances to the next entity, fetching the next page when needed. It returns false
+// when there are no more entities or an error occurred.
+func (it *GraphSearchIterator) Next(ctx context.Context) bool {
+	for len(it.entities) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		page, err := it.client.GraphSearchPage(ctx, it.query, it.pageSize, it.cursor)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.totalCount = page.TotalCount
+		for _, node := range page.Nodes {
+			it.entities = append(it.entities, node.Entities...)
+		}
+		it.cursor = page.PageInfo.EndCursor
+		it.done = !page.PageInfo.HasNextPage || it.cursor == ""
+	}
+
+	it.current, it.entities = it.entities[0], it.entities[1:]
+	return true
+}
+
+// Entity returns the current entity
+func (it *GraphSearchIterator) Entity() GraphEntity {
+	return it.current
+}
+
+// TotalCount returns the total number of matches reported with the last page
+func (it *GraphSearchIterator) TotalCount() int {
+	return it.totalCount
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *GraphSearchIterator) Err() error {
+	return it.err
+}
+
+// CollectEntities returns every entity matching the query, at most limit when limit is positive
+func (w *WizAPI) CollectEntities(ctx context.Context, query *Query, limit int) ([]GraphEntity, error) {
+	var entities []GraphEntity
+	it := w.SearchEntities(query, 0)
+	for it.Next(ctx) {
+		entities = append(entities, it.Entity())
+		if limit > 0 && len(entities) >= limit {
+			break
+		}
+	}
+	return entities, it.Err()
+}
+
+// StringProperty returns a property as a string, or an empty string when it is missing
+func (e GraphEntity) StringProperty(name string) string {
+	switch value := e.Properties[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// ExternalID returns the cloud provider ID of the entity
+func (e GraphEntity) ExternalID() string {
+	return e.StringProperty("externalId")
+}
+
+// CloudPlatform returns the cloud platform of the entity, e.g. AWS
+func (e GraphEntity) CloudPlatform() string {
+	return e.StringProperty("cloudPlatform")
+}
+
+// Region returns the cloud region of the entity
+func (e GraphEntity) Region() string {
+	return e.StringProperty("region")
+}
+
+// SubscriptionExternalID returns the account, subscription or project ID the entity belongs to
+func (e GraphEntity) SubscriptionExternalID() string {
+	return e.StringProperty("subscriptionExternalId")
+}
+
+// NativeType returns the provider's type of the entity, e.g. virtualMachine
+func (e GraphEntity) NativeType() string {
+	return e.StringProperty("nativeType")
+}
+
+// Status returns the state of the entity, e.g. Active
+func (e GraphEntity) Status() string {
+	return e.StringProperty("status")
+}
+
+// Tags returns the tags of the entity. Wiz reports them either as an object or as a list of key/value pairs.
+func (e GraphEntity) Tags() map[string]string {
+	tags := make(map[string]string)
+	switch value := e.Properties["tags"].(type) {
+	case map[string]interface{}:
+		for key, tag := range value {
+			tags[key] = fmt.Sprint(tag)
+		}
+	case []interface{}:
+		for _, item := range value {
+			if pair, ok := item.(map[string]interface{}); ok {
+				key, _ := pair["key"].(string)
+				tags[key] = fmt.Sprint(pair["value"])
+			}
+		}
+	}
+	return tags
+}
+
+// String describes the entity for people picking between candidates
+func (e GraphEntity) String() string {
+	var details []string
+	for _, detail := range []struct{ name, value string }{
+		{"externalId", e.ExternalID()},
+		{"subscription", e.SubscriptionExternalID()},
+		{"region", e.Region()},
+		{"status", e.Status()},
+	} {
+		if detail.value != "" {
+			details = append(details, detail.name+"="+detail.value)
+		}
+	}
+	return fmt.Sprintf("%s %s (%s)", e.ID, e.Name, strings.Join(details, ", "))
+}
+
+// VMCriteria identifies the virtual machine a scan is reported for. Only CloudPlatform and
+// ProviderID are used for the search, the rest narrows down multiple matches.
+type VMCriteria struct {
+	CloudPlatform  string
+	ProviderID     string
+	SubscriptionID string
+	Region         string
+	Hostname       string
+	HostnameTags   []string // Tags holding the host name, DefaultHostnameTags when empty
+}
+
+// ResolveError is returned when the criteria match no virtual machine or more than one
+type ResolveError struct {
+	Criteria   VMCriteria
+	Candidates []GraphEntity // Matches left after narrowing down, empty when nothing matched
+	Reasons    []string      // How the candidates were narrowed down
+}
+
+// Error explains the outcome and lists the candidates
+func (e *ResolveError) Error() string {
+	var b strings.Builder
+	if len(e.Candidates) == 0 {
+		fmt.Fprintf(&b, "no virtual machine found for %s %s", e.Criteria.CloudPlatform, e.Criteria.ProviderID)
+	} else {
+		fmt.Fprintf(&b, "%d virtual machines match %s %s", len(e.Candidates), e.Criteria.CloudPlatform, e.Criteria.ProviderID)
+	}
+	for _, reason := range e.Reasons {
+		fmt.Fprintf(&b, "\n  %s", reason)
+	}
+	for _, candidate := range e.Candidates {
+		fmt.Fprintf(&b, "\n  candidate: %s", candidate)
+	}
+	return b.String()
+}
+
+// Ambiguous reports whether several candidates are left
+func (e *ResolveError) Ambiguous() bool {
+	return len(e.Candidates) > 1
+}
+
+// narrow keeps the candidates matching the predicate, unless none would be left
+func narrow(candidates []GraphEntity, description string, matches func(GraphEntity) bool, reasons *[]string) []GraphEntity {
+	var kept []GraphEntity
+	for _, candidate := range candidates {
+		if matches(candidate) {
+			kept = append(kept, candidate)
+		}
+	}
+	if len(kept) == 0 {
+		*reasons = append(*reasons, fmt.Sprintf("no candidate has %s, ignored", description))
+		return candidates
+	}
+	*reasons = append(*reasons, fmt.Sprintf("%d of %d candidates have %s", len(kept), len(candidates), description))
+	return kept
+}
+
+// ResolveVM finds the virtual machine matching the criteria. When several match, they are narrowed
+// down by subscription, region and host name tag. A *ResolveError lists the candidates when no
+// single virtual machine is left.
+func (w *WizAPI) ResolveVM(ctx context.Context, criteria VMCriteria) (*GraphEntity, error) {
+	candidates, err := w.CollectEntities(ctx, VMQuery(criteria.CloudPlatform, criteria.ProviderID), 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 1 {
+		return &candidates[0], nil
+	}
+	if len(candidates) == 0 {
+		return nil, &ResolveError{Criteria: criteria}
+	}
+
+	var reasons []string
+	if criteria.SubscriptionID != "" {
+		candidates = narrow(candidates, "subscription "+criteria.SubscriptionID, func(e GraphEntity) bool {
+			return strings.EqualFold(e.SubscriptionExternalID(), criteria.SubscriptionID)
+		}, &reasons)
+	}
+	if criteria.Region != "" && len(candidates) > 1 {
+		candidates = narrow(candidates, "region "+criteria.Region, func(e GraphEntity) bool {
+			return strings.EqualFold(e.Region(), criteria.Region)
+		}, &reasons)
+	}
+	if criteria.Hostname != "" && len(candidates) > 1 {
+		tagNames := criteria.HostnameTags
+		if len(tagNames) == 0 {
+			tagNames = DefaultHostnameTags
+		}
+		candidates = narrow(candidates, "host name "+criteria.Hostname, func(e GraphEntity) bool {
+			if strings.EqualFold(e.Name, criteria.Hostname) {
+				return true
+			}
+			tags := e.Tags()
+			for _, tagName := range tagNames {
+				if strings.EqualFold(tags[tagName], criteria.Hostname) {
+					return true
+				}
+			}
+			return false
+		}, &reasons)
+	}
+
+	if len(candidates) == 1 {
+		return &candidates[0], nil
+	}
+	return nil, &ResolveError{Criteria: criteria, Candidates: candidates, Reasons: reasons}
+}
@@ -0,0 +1,129 @@
+// File: pkg/wizapi/token.go
+
+package wizapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokenRefreshMargin is how long before its expiry a token is replaced. Short-lived tokens are
+// replaced a tenth of their issued lifetime before expiry instead.
+const tokenRefreshMargin = 5 * time.Minute
+
+// tokenResponse is the response of the OAuth client credentials grant
+type tokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   json.Number `json:"expires_in"`
+	TokenType   string      `json:"token_type"`
+}
+
+// Authenticate authenticates with the WizAPI and stores the auth token
+func (w *WizAPI) Authenticate() error {
+	return w.AuthenticateContext(context.Background())
+}
+
+// AuthenticateContext is Authenticate bound to a context
+func (w *WizAPI) AuthenticateContext(ctx context.Context) error {
+	w.tokenMu.Lock()
+	defer w.tokenMu.Unlock()
+	return w.authenticateLocked(ctx)
+}
+
+// authenticateLocked requests a new token, the caller holds tokenMu
+func (w *WizAPI) authenticateLocked(ctx context.Context) error {
+	// Construct the request data
+	requestData := url.Values{}
+	requestData.Set("audience", "wiz-api")
+	requestData.Set("grant_type", "client_credentials")
+	requestData.Set("client_id", w.ClientID)
+	requestData.Set("client_secret", w.ClientSecret)
+
+	// Send a POST request to the Wiz API authentication endpoint, requesting a token twice is harmless
+	requestedAt := time.Now()
+	response, err := w.Retry.Do(ctx, w.Session, true, func(ctx context.Context) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, "POST", w.ClientAuthURL, strings.NewReader(requestData.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return request, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error authenticating to the Wiz API: %w", err)
+	}
+	defer response.Body.Close()
+
+	// Handle non-200 status
+	if response.StatusCode != 200 {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("authentication failed with status: %s - %s", response.Status, string(body))
+	}
+
+	// Decode the response
+	var token tokenResponse
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return fmt.Errorf("error parsing authentication response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return errors.New("no access token found in the response")
+	}
+
+	// Store the access token and when it expires, measured from when it was requested
+	w.AuthToken = token.AccessToken
+	w.TokenExpiry, w.tokenLifetime = time.Time{}, 0
+	if seconds, err := token.ExpiresIn.Float64(); err == nil && seconds > 0 {
+		w.tokenLifetime = time.Duration(seconds * float64(time.Second))
+		w.TokenExpiry = requestedAt.Add(w.tokenLifetime)
+	}
+	return nil
+}
+
+// tokenNeedsRefresh reports whether the token is missing or about to expire, the caller holds tokenMu
+func (w *WizAPI) tokenNeedsRefresh(now time.Time) bool {
+	if w.AuthToken == "" {
+		return true
+	}
+	if w.TokenExpiry.IsZero() {
+		return false // Without an expiry the token is replaced when the API rejects it
+	}
+
+	// The margin depends on the issued lifetime, not on the time left, so it doesn't shrink towards expiry
+	margin := tokenRefreshMargin
+	if w.tokenLifetime > 0 && w.tokenLifetime/10 < margin {
+		margin = w.tokenLifetime / 10
+	}
+	return !now.Before(w.TokenExpiry.Add(-margin))
+}
+
+// accessToken returns a valid token, authenticating first when there is none or it is about to expire
+func (w *WizAPI) accessToken(ctx context.Context) (string, error) {
+	w.tokenMu.Lock()
+	defer w.tokenMu.Unlock()
+
+	if w.tokenNeedsRefresh(time.Now()) {
+		if err := w.authenticateLocked(ctx); err != nil {
+			return "", err
+		}
+	}
+	return w.AuthToken, nil
+}
+
+// invalidateToken drops the token the API rejected. Callers that already replaced it keep the new one,
+// so concurrent requests failing with the same token authenticate only once.
+func (w *WizAPI) invalidateToken(rejected string) {
+	w.tokenMu.Lock()
+	defer w.tokenMu.Unlock()
+
+	if w.AuthToken == rejected {
+		w.AuthToken = ""
+		w.TokenExpiry, w.tokenLifetime = time.Time{}, 0
+	}
+}
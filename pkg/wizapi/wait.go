@@ -0,0 +1,143 @@
+package wizapi
+
+import (
+	"context"
+	"fmt"
+	"scanapp/pkg/retry"
+	"time"
+)
+
+// DefaultWaitTimeout is how long WaitForSystemActivity waits for an upload to be processed by default
+const DefaultWaitTimeout = 10 * time.Minute
+
+// WaitState is the terminal state of WaitForSystemActivity
+type WaitState string
+
+const (
+	// WaitSucceeded means the activity finished successfully
+	WaitSucceeded WaitState = "succeeded"
+	// WaitFailed means the activity finished with a failure
+	WaitFailed WaitState = "failed"
+	// WaitTimedOut means the activity was still in progress when the timeout expired
+	WaitTimedOut WaitState = "timed out"
+	// WaitNotFound means the activity never showed up before the timeout expired
+	WaitNotFound WaitState = "not found"
+)
+
+// WaitOptions controls how WaitForSystemActivity polls
+type WaitOptions struct {
+	// Total time to wait, DefaultWaitTimeout when zero
+	Timeout time.Duration
+	// Delay between polls, only the delay fields of the policy are used
+	Backoff retry.Policy
+	// Called after every poll that did not reach a terminal state, may be nil
+	OnProgress func(progress WaitProgress)
+}
+
+// WaitProgress describes a poll that did not reach a terminal state
+type WaitProgress struct {
+	Attempt   int
+	Activity  *SystemActivity // nil while the activity is not found
+	Elapsed   time.Duration
+	NextDelay time.Duration
+}
+
+// WaitResult is the outcome of WaitForSystemActivity
+type WaitResult struct {
+	State    WaitState
+	Activity *SystemActivity // The last activity seen, nil when it was never found
+	Attempts int
+	Elapsed  time.Duration
+}
+
+// DefaultWaitOptions returns the options used after uploads: polls from 5s apart growing to one minute, for up to 10 minutes
+func DefaultWaitOptions() WaitOptions {
+	return WaitOptions{
+		Timeout: DefaultWaitTimeout,
+		Backoff: retry.Policy{
+			InitialDelay: 5 * time.Second,
+			MaxDelay:     time.Minute,
+			Multiplier:   2,
+			Jitter:       0.2,
+		},
+	}
+}
+
+// WaitForSystemActivity polls a system activity until it succeeds or fails, or the timeout expires.
+// The timeout also bounds the polls themselves, including their retries.
+// The activity may not exist yet right after the upload, so not-found is only terminal at the timeout.
+// Other API errors and cancellation of ctx are returned as errors.
+func (w *WizAPI) WaitForSystemActivity(ctx context.Context, id string, opts WaitOptions) (*WaitResult, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	started := time.Now()
+	deadline := started.Add(timeout)
+
+	parent := ctx
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	result := &WaitResult{}
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+
+		activity, err := w.QuerySystemActivity(ctx, id)
+		if err != nil && !IsNotFound(err) {
+			result.Elapsed = time.Since(started)
+			if parent.Err() == nil && ctx.Err() != nil {
+				return result.expired(), nil // The poll ran into the timeout
+			}
+			return result, fmt.Errorf("querying system activity %s: %w", id, err)
+		}
+		if err == nil {
+			result.Activity = activity
+			switch activity.Status {
+			case ActivitySuccess:
+				result.State = WaitSucceeded
+			case ActivityInProgress:
+			default:
+				result.State = WaitFailed
+			}
+		}
+		result.Elapsed = time.Since(started)
+		if result.State != "" {
+			return result, nil
+		}
+
+		// Stop when the next poll would be past the deadline
+		delay := opts.Backoff.Backoff(attempt + 1)
+		if remaining := time.Until(deadline); remaining <= 0 {
+			return result.expired(), nil
+		} else if delay > remaining {
+			delay = remaining
+		}
+
+		if opts.OnProgress != nil {
+			opts.OnProgress(WaitProgress{Attempt: attempt, Activity: activity, Elapsed: result.Elapsed, NextDelay: delay})
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if parent.Err() == nil {
+				result.Elapsed = time.Since(started)
+				return result.expired(), nil
+			}
+			return result, parent.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// expired sets the state of a wait whose timeout expired, depending on whether the activity was found
+func (r *WaitResult) expired() *WaitResult {
+	if r.Activity == nil {
+		r.State = WaitNotFound
+	} else {
+		r.State = WaitTimedOut
+	}
+	return r
+}
@@ -0,0 +1,114 @@
+package wizapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"scanapp/pkg/retry"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowActivityServer answers the first polls with the given statuses, then stops answering until the
+// request is canceled
+type slowActivityServer struct {
+	mu       sync.Mutex
+	statuses []string
+	polls    int
+}
+
+func (s *slowActivityServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body) // The server notices a closed connection only once the body was read
+
+	s.mu.Lock()
+	poll := s.polls
+	s.polls++
+	s.mu.Unlock()
+
+	if poll < len(s.statuses) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"systemActivity": {"id": "activity", "status": "` + s.statuses[poll] + `"}}}`))
+		return
+	}
+
+	select {
+	case <-r.Context().Done():
+	case <-time.After(10 * time.Second):
+	}
+}
+
+// waitClient returns an authenticated client of the server
+func waitClient(server *httptest.Server) *WizAPI {
+	client := NewWizAPI(server.Client(), "id", "secret", server.URL+"/oauth/token", server.URL+"/graphql")
+	client.AuthToken = "token"
+	return client
+}
+
+// waitOptions returns options polling every few milliseconds for up to timeout
+func waitOptions(timeout time.Duration) WaitOptions {
+	return WaitOptions{
+		Timeout: timeout,
+		Backoff: retry.Policy{InitialDelay: 5 * time.Millisecond, MaxDelay: 5 * time.Millisecond, Multiplier: 1},
+	}
+}
+
+func TestWaitForSystemActivityTimeoutBoundsSlowPolls(t *testing.T) {
+	server := httptest.NewServer(&slowActivityServer{})
+	defer server.Close()
+
+	started := time.Now()
+	result, err := waitClient(server).WaitForSystemActivity(context.Background(), "activity", waitOptions(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("WaitForSystemActivity returned error: %v", err)
+	}
+	if result.State != WaitNotFound {
+		t.Errorf("state = %s, want %s", result.State, WaitNotFound)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("waited %s, past the 200ms timeout", elapsed)
+	}
+}
+
+func TestWaitForSystemActivityTimesOutInProgress(t *testing.T) {
+	server := httptest.NewServer(&slowActivityServer{statuses: []string{ActivityInProgress}})
+	defer server.Close()
+
+	result, err := waitClient(server).WaitForSystemActivity(context.Background(), "activity", waitOptions(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("WaitForSystemActivity returned error: %v", err)
+	}
+	if result.State != WaitTimedOut {
+		t.Errorf("state = %s, want %s", result.State, WaitTimedOut)
+	}
+	if result.Activity == nil || result.Activity.Status != ActivityInProgress {
+		t.Errorf("activity = %+v, want the in-progress activity", result.Activity)
+	}
+}
+
+func TestWaitForSystemActivityReturnsCallerCancellation(t *testing.T) {
+	server := httptest.NewServer(&slowActivityServer{})
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := waitClient(server).WaitForSystemActivity(ctx, "activity", waitOptions(time.Minute))
+	if err == nil {
+		t.Error("WaitForSystemActivity returned no error after the caller's context expired")
+	}
+}
+
+func TestWaitForSystemActivitySucceeds(t *testing.T) {
+	server := httptest.NewServer(&slowActivityServer{statuses: []string{ActivityInProgress, ActivitySuccess}})
+	defer server.Close()
+
+	result, err := waitClient(server).WaitForSystemActivity(context.Background(), "activity", waitOptions(time.Minute))
+	if err != nil {
+		t.Fatalf("WaitForSystemActivity returned error: %v", err)
+	}
+	if result.State != WaitSucceeded || result.Attempts != 2 {
+		t.Errorf("state = %s after %d attempts, want %s after 2", result.State, result.Attempts, WaitSucceeded)
+	}
+}
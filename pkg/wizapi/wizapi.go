@@ -4,14 +4,15 @@ package wizapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"scanapp/pkg/config"
+	"scanapp/pkg/retry"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -166,43 +167,31 @@ fragment UnresolvedAssetsDetails on EnrichmentIntegrationUnresolvedAssets {
 }
 `
 
-// RequestSecurityScanUploadResponse represents the response structure for the RequestSecurityScanUpload query
-type RequestSecurityScanUploadResponse struct {
-	Data struct {
-		RequestSecurityScanUpload struct {
-			Upload struct {
-				ID               string `json:"id"`
-				URL              string `json:"url"`
-				SystemActivityId string `json:"systemActivityId"`
-			} `json:"upload"`
-		} `json:"requestSecurityScanUpload"`
-	} `json:"data"`
-	Errors []GraphQLResourceError `json:"errors"` // Reuse the existing error struct
+// SecurityScanUpload is where to upload a security scan file and the system activity tracking it
+type SecurityScanUpload struct {
+	ID               string `json:"id"`
+	URL              string `json:"url"`
+	SystemActivityId string `json:"systemActivityId"`
 }
 
-// SystemActivityResponse is the expected response from the SystemActivity GraphQL query
-type SystemActivityResponse struct {
-	Data struct {
-		SystemActivity struct {
-			ID         string `json:"id"`
-			Status     string `json:"status"`
-			StatusInfo string `json:"statusInfo"`
-			Result     struct {
-				DataSources      IngestionStatsDetails `json:"dataSources"`
-				Findings         IngestionStatsDetails `json:"findings"`
-				Events           IngestionStatsDetails `json:"events"`
-				Tags             IngestionStatsDetails `json:"tags"`
-				UnresolvedAssets struct {
-					Count int      `json:"count"`
-					IDs   []string `json:"ids"`
-				} `json:"unresolvedAssets"`
-			} `json:"result"`
-			Context struct {
-				FileUploadId string `json:"fileUploadId"`
-			} `json:"context"`
-		} `json:"systemActivity"`
-	} `json:"data"`
-	Errors []GraphQLResourceError `json:"errors"`
+// SystemActivity is the processing status of an upload
+type SystemActivity struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	StatusInfo string `json:"statusInfo"`
+	Result     struct {
+		DataSources      IngestionStatsDetails `json:"dataSources"`
+		Findings         IngestionStatsDetails `json:"findings"`
+		Events           IngestionStatsDetails `json:"events"`
+		Tags             IngestionStatsDetails `json:"tags"`
+		UnresolvedAssets struct {
+			Count int      `json:"count"`
+			IDs   []string `json:"ids"`
+		} `json:"unresolvedAssets"`
+	} `json:"result"`
+	Context struct {
+		FileUploadId string `json:"fileUploadId"`
+	} `json:"context"`
 }
 
 type IngestionStatsDetails struct {
@@ -210,63 +199,82 @@ type IngestionStatsDetails struct {
 	Handled  int `json:"handled"`
 }
 
-type GraphQLResourceResponse struct {
-	Data struct {
-		GraphSearch struct {
-			MaxCountReached bool `json:"maxCountReached"`
-			TotalCount      int  `json:"totalCount"` // TotalCount is now directly mapped
-			Nodes           []struct {
-				AggregateCount interface{} `json:"aggregateCount"`
-				Entities       []struct {
-					ID           string                 `json:"id"`
-					Name         string                 `json:"name"`
-					Properties   map[string]interface{} `json:"properties"`
-					Technologies []struct {
-						ID   string `json:"id"`
-						Icon string `json:"icon"`
-					} `json:"technologies"`
-					Type         string      `json:"type"`
-					UserMetadata interface{} `json:"userMetadata"`
-				} `json:"entities"`
-			} `json:"nodes"`
-			PageInfo struct {
-				EndCursor   string `json:"endCursor"`
-				HasNextPage bool   `json:"hasNextPage"`
-			} `json:"pageInfo"`
-		} `json:"graphSearch"`
-	} `json:"data"`
-	Errors []GraphQLResourceError `json:"errors"`
+// GraphEntity is an entity returned by a graph search
+type GraphEntity struct {
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	Properties   map[string]interface{} `json:"properties"`
+	Technologies []struct {
+		ID   string `json:"id"`
+		Icon string `json:"icon"`
+	} `json:"technologies"`
+	Type         string      `json:"type"`
+	UserMetadata interface{} `json:"userMetadata"`
 }
 
-type GraphQLResourceError struct {
-	Message string `json:"message"`
+// GraphSearchResult is a page of graph search results
+type GraphSearchResult struct {
+	MaxCountReached bool `json:"maxCountReached"`
+	TotalCount      int  `json:"totalCount"`
+	Nodes           []struct {
+		AggregateCount interface{}   `json:"aggregateCount"`
+		Entities       []GraphEntity `json:"entities"`
+	} `json:"nodes"`
+	PageInfo struct {
+		EndCursor   string `json:"endCursor"`
+		HasNextPage bool   `json:"hasNextPage"`
+	} `json:"pageInfo"`
 }
 
-// GraphQLRequest represents a request to a GraphQL API.
-type GraphQLRequest struct {
-	Query     string                 `json:"query"`     // The GraphQL query string
-	Variables map[string]interface{} `json:"variables"` // Any variables used in the query
-}
+// The operations of the Wiz API used by scanapp
+var (
+	graphSearchOperation = Operation[struct {
+		GraphSearch GraphSearchResult `json:"graphSearch"`
+	}]{Name: "GraphSearch", Query: graphResourceSearchQuery}
+
+	securityScanUploadOperation = Operation[struct {
+		RequestSecurityScanUpload struct {
+			Upload SecurityScanUpload `json:"upload"`
+		} `json:"requestSecurityScanUpload"`
+	}]{Name: "RequestSecurityScanUpload", Query: graphFileUploadRequest}
+
+	systemActivityOperation = Operation[struct {
+		SystemActivity *SystemActivity `json:"systemActivity"`
+	}]{Name: "SystemActivity", Query: graphSystemActivityQuery}
+)
 
 // WizAPI struct holds the necessary information to interact with the WizAPI
 type WizAPI struct {
-	Session        *http.Client      // HTTP client to make requests
-	AuthToken      string            // Auth token received after successful authentication
-	WizAPI         map[string]string // Miscellaneous configurations
-	ClientID       string            // Client ID for WizAPI
-	ClientSecret   string            // Client Secret for WizAPI
-	ClientAuthURL  string            // Authentication URL for WizAPI
-	ClientQueryURL string            // Query URL for WizAPI
+	Session        *http.Client // HTTP client to make requests
+	Retry          retry.Policy // When to send failed requests again
+	AuthToken      string       // Auth token received after successful authentication, guarded by tokenMu
+	TokenExpiry    time.Time    // When AuthToken expires, zero if the token response had no expiry
+	ClientID       string       // Client ID for WizAPI
+	ClientSecret   string       // Client Secret for WizAPI
+	ClientAuthURL  string       // Authentication URL for WizAPI
+	ClientQueryURL string       // Query URL for WizAPI
+
+	tokenMu       sync.Mutex    // Serializes authentication between concurrent callers
+	tokenLifetime time.Duration // Lifetime AuthToken was issued with, guarded by tokenMu
 }
 
-// NewWizAPI creates and returns a new instance of WizAPI
-func NewWizAPI(clientID, clientSecret, clientAuthURL, clientQueryURL string) *WizAPI {
+// retryPolicy returns the default retry policy, logging every retry
+func retryPolicy() retry.Policy {
+	policy := retry.Default()
+	policy.OnRetry = func(attempt int, delay time.Duration, reason string) {
+		log.Printf("Retrying Wiz API request in %s (attempt %d) after %s\n", delay.Round(time.Millisecond), attempt, reason)
+	}
+	return policy
+}
+
+// NewWizAPI creates and returns a new instance of WizAPI. session may be nil to use a default client.
+func NewWizAPI(session *http.Client, clientID, clientSecret, clientAuthURL, clientQueryURL string) *WizAPI {
+	if session == nil {
+		session = &http.Client{Timeout: 60 * time.Second}
+	}
 	return &WizAPI{
-		Session: &http.Client{Timeout: 60 * time.Second},
-		WizAPI: map[string]string{
-			"proxy":           "",    // Define if any proxy is used
-			"wiz_req_timeout": "300", // Request timeout in seconds
-		},
+		Session:        session,
+		Retry:          retryPolicy(),
 		ClientID:       clientID,
 		ClientSecret:   clientSecret,
 		ClientAuthURL:  clientAuthURL,
@@ -274,94 +282,19 @@ func NewWizAPI(clientID, clientSecret, clientAuthURL, clientQueryURL string) *Wi
 	}
 }
 
-// Authenticate authenticates with the WizAPI and stores the auth token
-func (w *WizAPI) Authenticate() error {
-	// Construct the request data
-	requestData := url.Values{}
-	requestData.Set("audience", "wiz-api")
-	requestData.Set("grant_type", "client_credentials")
-	requestData.Set("client_id", w.ClientID)
-	requestData.Set("client_secret", w.ClientSecret)
-
-	// Send a POST request to the Wiz API authentication endpoint
-	response, err := w.Session.PostForm(w.ClientAuthURL, requestData)
-	if err != nil {
-		return fmt.Errorf("error authenticating to the Wiz API: %w", err)
-	}
-	defer response.Body.Close()
-
-	// Handle non-200 status
-	if response.StatusCode != 200 {
-		body, _ := io.ReadAll(response.Body)
-		return fmt.Errorf("authentication failed with status: %s - %s", response.Status, string(body))
-	}
-
-	// Decode the response
-	var responseData map[string]interface{}
-	if err := json.NewDecoder(response.Body).Decode(&responseData); err != nil {
-		return fmt.Errorf("error parsing authentication response: %w", err)
-	}
-
-	// Extract the access token from the response
-	token, ok := responseData["access_token"].(string)
-	if !ok {
-		return errors.New("no access token found in the response")
-	}
-
-	// Store the access token
-	w.AuthToken = token
-	return nil
-}
-
-func (w *WizAPI) GraphResourceSearch(cfg *config.Config) (*GraphQLResourceResponse, error) {
-	queryVariables := resourceCreateQueryVariables(cfg.ScanCloudType, cfg.ScanProviderID)
-	query := graphResourceSearchQuery // Your GraphQL query
-
-	response, err := w.QueryWithRetry(query, queryVariables)
-	if err != nil {
-		return nil, fmt.Errorf("error querying with retry: %w", err)
-	}
-
-	// Process the HTTP response and unmarshal the JSON into GraphQLResourceResponse
-	var graphQLResourceResponse GraphQLResourceResponse
-	if err := json.NewDecoder(response.Body).Decode(&graphQLResourceResponse); err != nil {
-		return nil, fmt.Errorf("error unmarshaling response: %w", err)
-	}
-
-	if len(graphQLResourceResponse.Errors) > 0 {
-		return nil, fmt.Errorf("graphql errors: %v", graphQLResourceResponse.Errors)
-	}
-
-	return &graphQLResourceResponse, nil
-}
-
-func resourceCreateQueryVariables(scanCloudType, scanProviderID string) map[string]interface{} {
-	return map[string]interface{}{
-		"quick": true,
-		"first": 50,
-		"query": map[string]interface{}{
-			"type":   []string{"VIRTUAL_MACHINE"},
-			"select": true,
-			"where": map[string]interface{}{
-				"cloudPlatform": map[string]interface{}{
-					"EQUALS": []string{scanCloudType},
-				},
-				"externalId": map[string]interface{}{
-					"EQUALS": []string{scanProviderID},
-				},
-			},
-		},
-		"projectId":       "*",
-		"fetchTotalCount": true,
-	}
+// GraphResourceSearch returns the first page of virtual machines matching the scan's provider ID
+func (w *WizAPI) GraphResourceSearch(ctx context.Context, cfg *config.Config) (*GraphSearchResult, error) {
+	return w.GraphSearchPage(ctx, VMQuery(cfg.ScanCloudType, cfg.ScanProviderID), DefaultPageSize, "")
 }
 
-// QueryWithRetry attempts to send a GraphQL query and retries if certain conditions are met.
+// QueryWithRetry attempts to send a GraphQL query and retries on transient failures following w.Retry.
+// When the retries are exhausted the last response is returned.
 func (w *WizAPI) QueryWithRetry(query string, variables map[string]interface{}) (*http.Response, error) {
-	// Define how many times you want to retry and the delay between retries
-	maxRetries := 3
-	retryDelay := time.Second * 2
+	return w.QueryWithRetryContext(context.Background(), query, variables)
+}
 
+// QueryWithRetryContext is QueryWithRetry bound to a context. The caller closes the response body.
+func (w *WizAPI) QueryWithRetryContext(ctx context.Context, query string, variables map[string]interface{}) (*http.Response, error) {
 	// Prepare the request data
 	data := map[string]interface{}{
 		"query":     query,
@@ -375,120 +308,73 @@ func (w *WizAPI) QueryWithRetry(query string, variables map[string]interface{})
 		return nil, err
 	}
 
-	// Create the HTTP request
-	request, err := http.NewRequest("POST", w.ClientQueryURL, bytes.NewBuffer(jsonData))
+	// Queries can be sent twice safely, mutations only when they never reached the server
+	idempotent := !strings.HasPrefix(strings.TrimSpace(query), "mutation")
+
+	// Get a token, authenticating again if it is about to expire
+	token, err := w.accessToken(ctx)
 	if err != nil {
-		log.Printf("Error creating request: %s\n", err)
 		return nil, err
 	}
 
-	// Set necessary headers
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", w.AuthToken))
-	request.Header.Add("Accept", "application/json")
-	request.Header.Set("Content-Type", "application/json")
-
-	// Initialize response variable
-	var response *http.Response
+	response, err := w.sendWithRetry(ctx, jsonData, token, idempotent)
+	if err != nil {
+		return nil, err
+	}
 
-	// Attempt the request with retries
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		response, err = w.Session.Do(request)
-		if err != nil {
-			log.Printf("Error querying Wiz API: %s\n", err)
-			time.Sleep(retryDelay) // Wait before retrying
-			continue               // Proceed to the next attempt
-		}
+	// The token may have been revoked or expired early, authenticate again and retry once
+	if response.StatusCode == http.StatusUnauthorized {
+		response.Body.Close()
+		log.Printf("Wiz API rejected the token, authenticating again\n")
 
-		// If the status code is not one of the retryable ones, break the loop
-		if !w.RetryableResponseStatusCode(response.StatusCode) {
-			break
+		w.invalidateToken(token)
+		if token, err = w.accessToken(ctx); err != nil {
+			return nil, err
 		}
-
-		// Close the previous response body to avoid leaks
-		if response.Body != nil {
-			response.Body.Close()
-		}
-
-		log.Printf("Retrying due to status code: %d, attempt: %d\n", response.StatusCode, attempt+1)
-		time.Sleep(retryDelay) // Wait before retrying
-	}
-
-	// After the loop, check why the function exited the loop
-	if err != nil {
-		// If there was an error in the last attempt, return it
-		return nil, err
-	} else if response != nil && !w.RetryableResponseStatusCode(response.StatusCode) {
-		// If the last response had a non-retryable status code, return the response
-		return response, nil
+		return w.sendWithRetry(ctx, jsonData, token, idempotent)
 	}
 
-	// If none of the above conditions were met, it means all retries were exhausted
-	return nil, fmt.Errorf("max retries reached with status code: %d", response.StatusCode)
+	return response, nil
 }
 
-// RetryableResponseStatusCode determines whether a given HTTP status code is retryable
-func (w *WizAPI) RetryableResponseStatusCode(statusCode int) bool {
-	// Define which status codes are considered retryable
-	switch statusCode {
-	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
-		return true
-	default:
-		return false
-	}
+// sendWithRetry posts the query with the given token and retries on transient failures.
+// The request is rebuilt for every attempt so each attempt carries the full body.
+func (w *WizAPI) sendWithRetry(ctx context.Context, jsonData []byte, token string, idempotent bool) (*http.Response, error) {
+	return w.Retry.Do(ctx, w.Session, idempotent, func(ctx context.Context) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, "POST", w.ClientQueryURL, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		// Set necessary headers
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		request.Header.Add("Accept", "application/json")
+		request.Header.Set("Content-Type", "application/json")
+		return request, nil
+	})
 }
 
 // RequestSecurityScanUpload sends a query to request a security scan upload URL and ID for a file
-func (w *WizAPI) RequestSecurityScanUpload(filename string) (*RequestSecurityScanUploadResponse, error) {
-	// Prepare the variables for the query
-	variables := map[string]interface{}{
+func (w *WizAPI) RequestSecurityScanUpload(ctx context.Context, filename string) (*SecurityScanUpload, error) {
+	data, err := Do(ctx, w, securityScanUploadOperation, map[string]interface{}{
 		"filename": filename,
-	}
-
-	// Execute the query using the constant graphFileUploadRequest
-	response, err := w.QueryWithRetry(graphFileUploadRequest, variables)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error querying with retry: %w", err)
-	}
-
-	// Process the HTTP response and unmarshal the JSON into RequestSecurityScanUploadResponse
-	var uploadResponse RequestSecurityScanUploadResponse
-	if err := json.NewDecoder(response.Body).Decode(&uploadResponse); err != nil {
-		return nil, fmt.Errorf("error unmarshaling response: %w", err)
-	}
-
-	// Handle any errors in the response
-	if len(uploadResponse.Errors) > 0 {
-		return nil, fmt.Errorf("graphql errors: %v", uploadResponse.Errors)
+		return nil, err
 	}
-
-	return &uploadResponse, nil
+	return &data.RequestSecurityScanUpload.Upload, nil
 }
 
 // QuerySystemActivity performs the SystemActivity GraphQL query with the given ID.
-func (w *WizAPI) QuerySystemActivity(systemActivityID string) (*SystemActivityResponse, error) {
-	// Prepare the variables for the query
-	variables := map[string]interface{}{
+func (w *WizAPI) QuerySystemActivity(ctx context.Context, systemActivityID string) (*SystemActivity, error) {
+	data, err := Do(ctx, w, systemActivityOperation, map[string]interface{}{
 		"id": systemActivityID,
-	}
-
-	// Use QueryWithRetry to perform the query with built-in retry logic
-	response, err := w.QueryWithRetry(graphSystemActivityQuery, variables)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error querying system activity with retry: %w", err)
-	}
-	defer response.Body.Close()
-
-	// Decode the response into the SystemActivityResponse struct
-	var systemActivityResponse SystemActivityResponse
-	if err := json.NewDecoder(response.Body).Decode(&systemActivityResponse); err != nil {
-		return nil, fmt.Errorf("error unmarshaling response: %w", err)
+		return nil, err
 	}
-
-	// Check for GraphQL errors
-	if len(systemActivityResponse.Errors) > 0 {
-		return nil, fmt.Errorf("graphql errors: %v", systemActivityResponse.Errors)
+	if data.SystemActivity == nil {
+		return nil, fmt.Errorf("system activity %s: %w", systemActivityID, ErrNotFound)
 	}
-
-	// Return the parsed response
-	return &systemActivityResponse, nil
+	return data.SystemActivity, nil
 }
@@ -10,9 +10,22 @@ import (
 	"strings"
 )
 
+// ScanResult holds the outcome of scanning a single directory
+type ScanResult struct {
+	Directory string // Directory that was scanned
+	JSON      string // JSON output of wizcli, empty if the scan failed
+	Error     string // Reason the scan failed, empty on success
+}
+
+// Succeeded reports whether the scan produced usable output
+func (r ScanResult) Succeeded() bool {
+	return r.Error == ""
+}
+
 // ScanDirectories receives a slice of directory paths and a wizCliPath, then scans each directory.
-func ScanDirectories(directories []string, wizCliPath string) ([]string, error) {
-	var jsonOutputs []string // Slice to store the JSON outputs
+// Directories whose output cannot be parsed are returned as failed results.
+func ScanDirectories(directories []string, wizCliPath string) ([]ScanResult, error) {
+	var results []ScanResult // Slice to store the result of each scan
 
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -49,14 +62,15 @@ func ScanDirectories(directories []string, wizCliPath string) ([]string, error)
 		jsonOutput, err := extractJSON(string(output))
 		if err != nil {
 			log.Printf("Unknown error parsing scan results for directory %s: %v", dir, err)
-			continue // Optionally continue to the next directory or return the error based on your requirements
+			results = append(results, ScanResult{Directory: dir, Error: err.Error()})
+			continue // Record the failure and continue to the next directory
 		}
 
 		// Append the JSON output to the slice
-		jsonOutputs = append(jsonOutputs, jsonOutput)
+		results = append(results, ScanResult{Directory: dir, JSON: jsonOutput})
 	}
 
-	return jsonOutputs, nil // Return the slice of JSON strings and nil as the error
+	return results, nil // Return the scan results and nil as the error
 }
 
 // extractJSON takes a string and returns the JSON part of it.
@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 // WizCliURLs holds the download URLs for wizcli binaries for different platforms and architectures.
@@ -29,13 +30,17 @@ func GetDownloadURL() (string, error) {
 }
 
 // DownloadFile downloads a URL to a local file. It's efficient because it writes as it downloads and doesn't load the whole file into memory.
-func DownloadFile(filepath string, url string) error {
-	resp, err := http.Get(url)
+func DownloadFile(client *http.Client, filepath string, url string) error {
+	resp, err := client.Get(url)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+
 	out, err := os.Create(filepath)
 	if err != nil {
 		return err
@@ -46,8 +51,8 @@ func DownloadFile(filepath string, url string) error {
 	return err
 }
 
-// SetupEnvironment creates a temporary directory, downloads wizcli, and sets up necessary permissions.
-func SetupEnvironment() (string, error) {
+// SetupEnvironment creates a temporary directory, downloads wizcli with the given client, and sets up necessary permissions.
+func SetupEnvironment(client *http.Client) (string, error) {
 	// Get the correct download URL for the platform
 	url, err := GetDownloadURL()
 	if err != nil {
@@ -67,7 +72,7 @@ func SetupEnvironment() (string, error) {
 	}
 
 	// Download the file
-	if err := DownloadFile(downloadPath, url); err != nil {
+	if err := DownloadFile(client, downloadPath, url); err != nil {
 		os.RemoveAll(tmpDir) // Clean up the temporary directory
 		return "", fmt.Errorf("error downloading wizcli: %v", err)
 	}
@@ -97,6 +102,17 @@ func AuthenticateWizcli(wizcliPath, wizClientID, wizClientSecret string) (string
 	return "wizcli authenticated successfully", nil
 }
 
+// Version returns the version reported by the downloaded wizcli binary.
+func Version(wizcliPath string) (string, error) {
+	cmd := exec.Command(wizcliPath, "version")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("wizcli version failed: %v - Output: %s", err, string(output))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
 // CleanupEnvironment removes the temporary directory and its contents.
 func CleanupEnvironment(downloadPath string) error {
 	// Extract the directory path from the full downloadPath